@@ -1,31 +1,162 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"eurocontrol.io/demo/egress/pkg/actuator"
 	"eurocontrol.io/demo/egress/pkg/api"
 	"eurocontrol.io/demo/egress/pkg/autoconfig"
+	"eurocontrol.io/demo/egress/pkg/egress"
+	"eurocontrol.io/demo/egress/pkg/logging"
+	"eurocontrol.io/demo/egress/pkg/metrics"
+	"eurocontrol.io/demo/egress/pkg/tracing"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 )
 
+// Configuration is the configuration of the service, loaded at once so that
+// every invalid property is reported on startup. The write timeout of the
+// HTTP server is disabled by default as it would cut the disturbance streams,
+// the routes calling upstream being bounded by their own timeouts.
+//
+// On SIGTERM or SIGINT the service turns unready, keeps serving for
+// DrainSeconds so that Kubernetes removes it from the endpoints, then waits
+// at most ShutdownTimeoutSeconds for the requests in flight.
 type Configuration struct {
-	RestPort int32 `value:"server.port|8000"`
+	RestPort                int32         `value:"server.port|8000" validate:"port"`
+	ReadHeaderTimeoutMillis time.Duration `value:"server.read-header-timeout|5000"`
+	ReadTimeoutMillis       time.Duration `value:"server.read-timeout|10000"`
+	WriteTimeoutMillis      time.Duration `value:"server.write-timeout|0"`
+	IdleTimeoutSeconds      time.Duration `value:"server.idle-timeout|120"`
+	DrainSeconds            time.Duration `value:"server.shutdown.drain|5"`
+	ShutdownTimeoutSeconds  time.Duration `value:"server.shutdown.timeout|20"`
+
+	Live        LiveConfiguration
+	Tracing     tracing.Config
+	Client      api.RailClientConfig
+	Retry       api.RetryConfig
+	Breaker     api.BreakerConfig
+	Actuator    actuator.Config
+	Diagnostics egress.DiagnosticsConfig
+	Proxy       egress.ProxyConfig
 }
 
+// LiveConfiguration is the part of the configuration applied without a
+// restart when the configuration files change: the logs, and the cache time
+// to live and timeouts of the routes.
+type LiveConfiguration struct {
+	Log logging.Config
+	API api.APIConfig
+}
 
 func main() {
-	config:=&Configuration{}
-	err:=autoconfig.AutoConfigure(config)
-	if err !=nil {
+	config := &Configuration{}
+	autoconfig.OrPanic(config)
+	live := &config.Live
+	err := logging.Configure(live.Log)
+	if err != nil {
+		panic(err)
+	}
+	err = tracing.Configure(config.Tracing)
+	if err != nil {
+		panic(err)
+	}
+
+	proxy, err := egress.NewProxy(config.Proxy)
+	if err != nil {
+		panic(err)
+	}
+
+	registry := metrics.NewRegistry()
+	client, err := api.NewRailClient(
+		api.WithConfig(config.Client),
+		api.WithRetry(config.Retry),
+		api.WithBreaker(config.Breaker),
+		api.WithProxy(proxy),
+		api.WithInstrumentation(api.NewMetrics(registry)),
+	)
+	if err != nil {
 		panic(err)
 	}
 	router := mux.NewRouter()
-	h:=api.NewRailAPI()
+	router.Use(tracing.Middleware, logging.Middleware)
+	h := api.NewRailAPI(live.API, client)
 	h.AddRoute(router)
-	server := &http.Server{Addr: fmt.Sprintf(":%d", config.RestPort), Handler: router}
-	err = server.ListenAndServe()
-	if err != nil && err != http.ErrServerClosed {
+	router.Handle("/metrics", registry.Handler()).Methods("GET")
+	health := actuator.New(config.Actuator, actuator.Check{Name: "upstream", Run: client.Ping})
+	health.AddRoute(router)
+	egress.NewDiagnostics(config.Diagnostics, egress.Upstream{Name: "irail", URL: config.Client.BaseURL, Proxy: client.Proxy()}).AddRoute(router)
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.RestPort),
+		Handler:           router,
+		ReadHeaderTimeout: config.ReadHeaderTimeoutMillis,
+		ReadTimeout:       config.ReadTimeoutMillis,
+		WriteTimeout:      config.WriteTimeoutMillis,
+		IdleTimeout:       config.IdleTimeoutSeconds,
+	}
+	server.RegisterOnShutdown(h.Shutdown)
+
+	watcher, err := autoconfig.Watch(live, func(cfg interface{}, changes []autoconfig.Change) {
+		reloaded := cfg.(*LiveConfiguration)
+		err := logging.Configure(reloaded.Log)
+		if err != nil {
+			logrus.WithError(err).Warn("log configuration not reloaded")
+		}
+		h.Reconfigure(reloaded.API)
+		for _, change := range changes {
+			logrus.WithFields(logrus.Fields{
+				"property": change.Property,
+				"old":      fmt.Sprint(change.Old),
+				"new":      fmt.Sprint(change.New),
+			}).Info("configuration reloaded")
+		}
+	}, autoconfig.WithErrorHandler(func(err error) {
+		logrus.WithError(err).Warn("configuration not reloaded")
+	}))
+	if err != nil {
+		panic(err)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	configFiles, _ := autoconfig.ConfigFiles()
+	logrus.WithFields(logrus.Fields{
+		"addr":         server.Addr,
+		"version":      actuator.BuildInfo().Version,
+		"proxy":        client.Proxy().String(),
+		"config_files": configFiles,
+		"profiles":     os.Getenv(autoconfig.AppProfilesEnv),
+	}).Info("server listening")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err = <-serverErr:
 		panic(err)
+	case sig := <-signals:
+		logrus.WithField("signal", sig.String()).Info("draining before shutdown")
+	}
+	health.Drain()
+	time.Sleep(config.DrainSeconds)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeoutSeconds)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("requests in flight dropped")
 	}
-}
\ No newline at end of file
+	err = tracing.Shutdown(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("spans not exported")
+	}
+	watcher.Close()
+	logrus.Info("server stopped")
+}
@@ -3,6 +3,7 @@ module eurocontrol.io/demo/egress
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gorilla/mux v1.8.0
 	github.com/sirupsen/logrus v1.2.0
 	github.com/spf13/viper v1.7.1
@@ -0,0 +1,190 @@
+// Package actuator serves the operational routes of the service: liveness and
+// readiness for the Kubernetes probes, and the build information injected by
+// the Makefile.
+package actuator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+// Build information, set with -ldflags -X by the Makefile.
+var (
+	buildTime   string
+	gitRevision string
+	gitBranch   string
+	version     string
+	name        string
+)
+
+// Info is the build information of the service.
+type Info struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	GitRevision string `json:"gitRevision"`
+	GitBranch   string `json:"gitBranch"`
+	BuildTime   string `json:"buildTime"`
+}
+
+// BuildInfo returns the build information of the service, empty when not
+// built by the Makefile.
+func BuildInfo() Info {
+	return Info{
+		Name:        name,
+		Version:     version,
+		GitRevision: gitRevision,
+		GitBranch:   gitBranch,
+		BuildTime:   buildTime,
+	}
+}
+
+// Config is the configuration of the readiness. The result of the checks is
+// kept for ReadinessCacheSeconds so that the probes do not hammer upstream,
+// each check being bounded by ReadinessTimeoutMillis.
+type Config struct {
+	ReadinessCacheSeconds  time.Duration `value:"actuator.readiness.cache-ttl|10"`
+	ReadinessTimeoutMillis time.Duration `value:"actuator.readiness.timeout|3000"`
+}
+
+// Check tells whether a dependency of the service is usable, returning the
+// reason why when it is not.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Status is UP or DOWN.
+type Status string
+
+const (
+	StatusUp   Status = "UP"
+	StatusDown Status = "DOWN"
+)
+
+// Health is the body of the health routes.
+type Health struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a check.
+type CheckResult struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Actuator serves the operational routes.
+type Actuator struct {
+	config Config
+	checks []Check
+	now    func() time.Time
+
+	mu        sync.Mutex
+	last      Health
+	checkedAt time.Time
+	draining  bool
+}
+
+// New returns an actuator whose readiness depends on the given checks.
+func New(config Config, checks ...Check) *Actuator {
+	return &Actuator{config: config, checks: checks, now: time.Now}
+}
+
+// Drain makes the service unready for good, so that Kubernetes stops sending
+// it traffic before it shuts down.
+func (a *Actuator) Drain() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.draining = true
+}
+
+// Ready runs the checks, or returns their last result while it is fresh. The
+// service is ready when all the checks pass and it is not draining. The checks
+// are not cancelled with ctx, so that a probe given up by the kubelet does not
+// cache a failure: they are bounded by ReadinessTimeoutMillis only.
+func (a *Actuator) Ready(ctx context.Context) Health {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.draining {
+		return Health{Status: StatusDown, Checks: map[string]CheckResult{
+			"shutdown": {Status: StatusDown, Error: "draining"},
+		}}
+	}
+	if !a.checkedAt.IsZero() && a.now().Before(a.checkedAt.Add(a.config.ReadinessCacheSeconds)) {
+		return a.last
+	}
+	health := Health{Status: StatusUp, Checks: map[string]CheckResult{}}
+	for _, check := range a.checks {
+		result := CheckResult{Status: StatusUp}
+		if err := a.run(ctx, check); err != nil {
+			result = CheckResult{Status: StatusDown, Error: err.Error()}
+			health.Status = StatusDown
+			logging.FromContext(ctx).WithError(err).WithField("check", check.Name).Warn("readiness check failed")
+		}
+		health.Checks[check.Name] = result
+	}
+	a.last, a.checkedAt = health, a.now()
+	return health
+}
+
+func (a *Actuator) run(ctx context.Context, check Check) error {
+	ctx = detached{ctx}
+	if a.config.ReadinessTimeoutMillis > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.config.ReadinessTimeoutMillis)
+		defer cancel()
+	}
+	return check.Run(ctx)
+}
+
+// detached keeps the values of a context, the request id and the trace for
+// instance, without its cancellation and deadline.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+func (a *Actuator) live() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Health{Status: StatusUp})
+	}
+}
+
+func (a *Actuator) ready() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := a.Ready(r.Context())
+		status := http.StatusOK
+		if health.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, health)
+	}
+}
+
+func (a *Actuator) info() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, BuildInfo())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *Actuator) AddRoute(router *mux.Router) {
+	router.HandleFunc("/health/live", a.live()).Methods("GET")
+	router.HandleFunc("/health/ready", a.ready()).Methods("GET")
+	router.HandleFunc("/info", a.info()).Methods("GET")
+}
@@ -0,0 +1,121 @@
+package actuator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, a *Actuator, target string) (*httptest.ResponseRecorder, Health) {
+	t.Helper()
+	router := mux.NewRouter()
+	a.AddRoute(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var health Health
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&health))
+	return rec, health
+}
+
+func TestLive(t *testing.T) {
+	failing := Check{Name: "upstream", Run: func(ctx context.Context) error { return errors.New("unreachable") }}
+
+	rec, health := serve(t, New(Config{}, failing), "/health/live")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, StatusUp, health.Status)
+}
+
+func TestReady(t *testing.T) {
+	var err error
+	calls := 0
+	upstream := Check{Name: "upstream", Run: func(ctx context.Context) error {
+		calls++
+		return err
+	}}
+	now := time.Now()
+	a := New(Config{ReadinessCacheSeconds: 10 * time.Second}, upstream)
+	a.now = func() time.Time { return now }
+
+	rec, health := serve(t, a, "/health/ready")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, Health{Status: StatusUp, Checks: map[string]CheckResult{"upstream": {Status: StatusUp}}}, health)
+
+	err = errors.New("proxyconnect tcp: connection refused")
+	rec, _ = serve(t, a, "/health/ready")
+	assert.Equal(t, http.StatusOK, rec.Code, "the result is cached")
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(10 * time.Second)
+	rec, health = serve(t, a, "/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, StatusDown, health.Status)
+	assert.Equal(t, CheckResult{Status: StatusDown, Error: "proxyconnect tcp: connection refused"}, health.Checks["upstream"])
+	assert.Equal(t, 2, calls)
+}
+
+func TestReady_Timeout(t *testing.T) {
+	slow := Check{Name: "upstream", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	rec, health := serve(t, New(Config{ReadinessTimeoutMillis: 10 * time.Millisecond}, slow), "/health/ready")
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "context deadline exceeded", health.Checks["upstream"].Error)
+}
+
+func TestReady_ProbeCancelled(t *testing.T) {
+	upstream := Check{Name: "upstream", Run: func(ctx context.Context) error {
+		return ctx.Err()
+	}}
+	a := New(Config{ReadinessCacheSeconds: 10 * time.Second, ReadinessTimeoutMillis: time.Second}, upstream)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	health := a.Ready(ctx)
+
+	assert.Equal(t, StatusUp, health.Status, "the checks outlive the probe")
+}
+
+func TestInfo(t *testing.T) {
+	name, version, gitRevision, gitBranch, buildTime = "demo-egress-http", "0.0.7-42", "1a2b3c4", "master", "2021-03-01-10:00"
+	t.Cleanup(func() { name, version, gitRevision, gitBranch, buildTime = "", "", "", "", "" })
+	router := mux.NewRouter()
+	New(Config{}).AddRoute(router)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/info", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"name": "demo-egress-http",
+		"version": "0.0.7-42",
+		"gitRevision": "1a2b3c4",
+		"gitBranch": "master",
+		"buildTime": "2021-03-01-10:00"
+	}`, rec.Body.String())
+}
+
+func TestReady_Draining(t *testing.T) {
+	a := New(Config{ReadinessCacheSeconds: time.Minute}, Check{Name: "upstream", Run: func(ctx context.Context) error { return nil }})
+	rec, _ := serve(t, a, "/health/ready")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	a.Drain()
+
+	rec, health := serve(t, a, "/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, CheckResult{Status: StatusDown, Error: "draining"}, health.Checks["shutdown"])
+	rec, _ = serve(t, a, "/health/live")
+	assert.Equal(t, http.StatusOK, rec.Code, "a draining service is still alive")
+}
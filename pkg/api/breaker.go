@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by RailClient without calling upstream while
+// the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open, upstream not called")
+
+// BreakerConfig is the configuration of the circuit breaker around iRail.
+// The circuit opens after FailureThreshold consecutive failures and stays
+// open for CoolDownSeconds. It then lets HalfOpenMaxCalls calls through: the
+// first success closes it, a failure opens it again and a cancelled call
+// leaves its place to another one.
+type BreakerConfig struct {
+	FailureThreshold int           `value:"rail.client.breaker.failure-threshold|5" validate:"min=1"`
+	CoolDownSeconds  time.Duration `value:"rail.client.breaker.cool-down|30"`
+	HalfOpenMaxCalls int           `value:"rail.client.breaker.half-open-max-calls|1" validate:"min=1"`
+}
+
+// DefaultBreakerConfig returns the circuit breaker configuration used when
+// none is given to NewRailClient. It matches the defaults of the value tags.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 5,
+		CoolDownSeconds:  30 * time.Second,
+		HalfOpenMaxCalls: 1,
+	}
+}
+
+// CircuitState is the state of the circuit breaker.
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitStatus is a snapshot of the circuit breaker.
+type CircuitStatus struct {
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+	RetryAt             *time.Time   `json:"retryAt,omitempty"`
+}
+
+type circuitBreaker struct {
+	config BreakerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trials   int
+	// generation changes with the state, so that the outcome of a call
+	// allowed before the last change is ignored.
+	generation int
+}
+
+func newCircuitBreaker(config BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config, now: time.Now, state: CircuitClosed}
+}
+
+// allow tells whether a call may go to upstream, along with the generation
+// the call belongs to. Every allowed call must be followed by a call to done
+// with that generation.
+func (b *circuitBreaker) allow() (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Before(b.openedAt.Add(b.config.CoolDownSeconds)) {
+			return b.generation, false
+		}
+		b.setState(CircuitHalfOpen)
+		b.trials = 0
+		fallthrough
+	case CircuitHalfOpen:
+		if b.trials >= b.config.HalfOpenMaxCalls {
+			return b.generation, false
+		}
+		b.trials++
+	}
+	return b.generation, true
+}
+
+// done records the outcome of a call allowed in the given generation. A
+// cancelled call tells nothing about upstream: it only frees its half-open
+// trial. The outcome of a call allowed before the last state change is
+// ignored.
+func (b *circuitBreaker) done(generation int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		if b.state == CircuitHalfOpen {
+			b.trials--
+		}
+		return
+	}
+	if !isUpstreamFailure(err) {
+		if b.state != CircuitClosed {
+			b.setState(CircuitClosed)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.setState(CircuitOpen)
+		b.openedAt = b.now()
+	}
+}
+
+func (b *circuitBreaker) setState(state CircuitState) {
+	b.state = state
+	b.generation++
+}
+
+func (b *circuitBreaker) status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := CircuitStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(b.config.CoolDownSeconds)
+		s.OpenedAt, s.RetryAt = &openedAt, &retryAt
+	}
+	return s
+}
+
+// isUpstreamFailure tells whether err shows that upstream is unhealthy.
+// Errors on the caller side, such as a missing station or a cancellation,
+// do not count.
+func isUpstreamFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode >= 500 || upstreamErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker_States(t *testing.T) {
+	now := time.Date(2020, 2, 16, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 2, CoolDownSeconds: 30 * time.Second, HalfOpenMaxCalls: 1})
+	b.now = func() time.Time { return now }
+	failure := &UpstreamError{StatusCode: http.StatusBadGateway}
+
+	generation, ok := b.allow()
+	require.True(t, ok)
+	b.done(generation, failure)
+	assert.Equal(t, CircuitClosed, b.status().State)
+	stale, ok := b.allow()
+	require.True(t, ok)
+	generation, ok = b.allow()
+	require.True(t, ok)
+	b.done(generation, failure)
+	assert.Equal(t, CircuitOpen, b.status().State)
+	_, ok = b.allow()
+	assert.False(t, ok)
+	b.done(stale, nil)
+	assert.Equal(t, CircuitOpen, b.status().State, "a call started before the circuit opened is ignored")
+
+	now = now.Add(31 * time.Second)
+	generation, ok = b.allow()
+	require.True(t, ok)
+	assert.Equal(t, CircuitHalfOpen, b.status().State)
+	_, ok = b.allow()
+	assert.False(t, ok, "a single trial call is allowed while half-open")
+	b.done(generation, context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, b.status().State, "a cancelled trial leaves the circuit half-open")
+	generation, ok = b.allow()
+	require.True(t, ok, "a cancelled trial frees its slot")
+	b.done(generation, failure)
+	assert.Equal(t, CircuitOpen, b.status().State)
+
+	now = now.Add(31 * time.Second)
+	generation, ok = b.allow()
+	require.True(t, ok)
+	b.done(generation, nil)
+	status := b.status()
+	assert.Equal(t, CircuitClosed, status.State)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	assert.Nil(t, status.RetryAt)
+}
+
+func TestCircuitBreaker_CallerErrorsDoNotCount(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 1, CoolDownSeconds: time.Second, HalfOpenMaxCalls: 1})
+
+	b.done(0, &UpstreamError{StatusCode: http.StatusNotFound})
+	b.done(0, context.Canceled)
+
+	assert.Equal(t, CircuitClosed, b.status().State)
+	b.done(0, errors.New("connection refused"))
+	assert.Equal(t, CircuitOpen, b.status().State)
+}
+
+func TestCircuitBreaker_ServesLastGood(t *testing.T) {
+	var failing int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, stationsPayload)
+	})
+	client.breaker = newCircuitBreaker(BreakerConfig{FailureThreshold: 1, CoolDownSeconds: time.Minute, HalfOpenMaxCalls: 1})
+	router := mux.NewRouter()
+	NewRailAPI(APIConfig{}, client).AddRoute(router)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, get("/stations").Code)
+	atomic.StoreInt32(&failing, 1)
+	require.Equal(t, http.StatusServiceUnavailable, get("/stations").Code)
+
+	rec := get("/stations")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Warning"), "110")
+	var list StationList
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&list))
+	assert.Len(t, list.Stations, 1)
+
+	rec = get("/disturbances")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	rec = get("/status/circuit")
+	var status CircuitStatus
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, CircuitOpen, status.State)
+	assert.NotNil(t, status.RetryAt)
+}
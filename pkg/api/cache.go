@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheStatus tells how a response was obtained, as reported by the X-Cache
+// header.
+type cacheStatus string
+
+const (
+	cacheHit   cacheStatus = "HIT"
+	cacheMiss  cacheStatus = "MISS"
+	cacheStale cacheStatus = "STALE"
+)
+
+// cacheEntry is a response body along with its validators.
+type cacheEntry struct {
+	body         []byte
+	etag         string
+	lastModified time.Time
+	expiresAt    time.Time
+	staleUntil   time.Time
+}
+
+// responseCache keeps the bodies served by the rail API. A fresh entry is
+// served as is. A stale entry is served while it is within the
+// stale-while-revalidate window and refreshed in the background. Concurrent
+// misses of the same key result in a single load, cancelled once all its
+// callers are gone. Entries are kept once expired so that they can still be
+// served while upstream is unavailable.
+type responseCache struct {
+	staleWhileRevalidate time.Duration
+	maxEntries           int
+	now                  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	flights map[string]*flight
+}
+
+// flight is a load in progress, shared by the callers of the same key.
+type flight struct {
+	done    chan struct{}
+	entry   cacheEntry
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+	// cancelled tells that all the callers left, the load being cancelled:
+	// the next caller starts a new flight.
+	cancelled bool
+}
+
+// newResponseCache returns a cache of at most maxEntries entries, 0 meaning
+// no limit.
+func newResponseCache(staleWhileRevalidate time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		staleWhileRevalidate: staleWhileRevalidate,
+		maxEntries:           maxEntries,
+		now:                  time.Now,
+		entries:              make(map[string]cacheEntry),
+		flights:              make(map[string]*flight),
+	}
+}
+
+// get returns the entry of the key, calling load when it is missing or
+// expired. A ttl of 0 loads on every call, the entry being kept for peek only.
+// The background refresh of a stale entry gets the time left before the
+// deadline of ctx, but is not cancelled with it.
+func (c *responseCache) get(ctx context.Context, key string, ttl time.Duration, load func(context.Context) ([]byte, error)) (cacheEntry, cacheStatus, error) {
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry, cacheHit, nil
+	}
+	if ok && now.Before(entry.staleUntil) {
+		go func() {
+			refreshCtx, cancel := detach(ctx)
+			defer cancel()
+			c.load(refreshCtx, key, ttl, load)
+		}()
+		return entry, cacheStale, nil
+	}
+	entry, err := c.load(ctx, key, ttl, load)
+	return entry, cacheMiss, err
+}
+
+// peek returns the entry of the key, even expired.
+func (c *responseCache) peek(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// load calls load once for all the concurrent callers of the key and stores
+// the result. A caller whose ctx is done stops waiting, and the load is
+// cancelled when no caller is left.
+func (c *responseCache) load(ctx context.Context, key string, ttl time.Duration, load func(context.Context) ([]byte, error)) (cacheEntry, error) {
+	c.mu.Lock()
+	f, ok := c.flights[key]
+	if !ok || f.cancelled {
+		loadCtx, cancel := detach(ctx)
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		c.flights[key] = f
+		go c.run(loadCtx, key, ttl, f, load)
+	}
+	f.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.entry, f.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			f.cancelled = true
+		}
+		c.mu.Unlock()
+		return cacheEntry{}, ctx.Err()
+	}
+}
+
+func (c *responseCache) run(ctx context.Context, key string, ttl time.Duration, f *flight, load func(context.Context) ([]byte, error)) {
+	body, err := load(ctx)
+	f.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+	if err == nil {
+		f.entry = c.store(key, ttl, body)
+	}
+	f.err = err
+	close(f.done)
+}
+
+// store records the body. Its Last-Modified only changes with its content.
+// Must be called with the lock held.
+func (c *responseCache) store(key string, ttl time.Duration, body []byte) cacheEntry {
+	now := c.now()
+	previous, ok := c.entries[key]
+	entry := cacheEntry{
+		body:         body,
+		etag:         etagOf(body),
+		lastModified: now.UTC().Truncate(time.Second),
+		expiresAt:    now.Add(ttl),
+		staleUntil:   now.Add(ttl),
+	}
+	if ttl > 0 {
+		entry.staleUntil = entry.expiresAt.Add(c.staleWhileRevalidate)
+	}
+	if ok && bytes.Equal(previous.body, body) {
+		entry.lastModified = previous.lastModified
+	}
+	if !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+	c.entries[key] = entry
+	return entry
+}
+
+// evict removes the entry that went stale first. Must be called with the
+// lock held.
+func (c *responseCache) evict() {
+	var oldest string
+	var oldestStaleUntil time.Time
+	for k, e := range c.entries {
+		if oldest == "" || e.staleUntil.Before(oldestStaleUntil) {
+			oldest, oldestStaleUntil = k, e.staleUntil
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeCacheHeaders sets the validators and the freshness of the entry.
+func (c *responseCache) writeCacheHeaders(w http.ResponseWriter, entry cacheEntry, status cacheStatus) {
+	h := w.Header()
+	h.Set("ETag", entry.etag)
+	h.Set("Last-Modified", entry.lastModified.Format(http.TimeFormat))
+	h.Set("X-Cache", string(status))
+	maxAge := int(entry.expiresAt.Sub(c.now()).Seconds())
+	if maxAge <= 0 {
+		h.Set("Cache-Control", "no-cache")
+		return
+	}
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d", maxAge, int(c.staleWhileRevalidate.Seconds())))
+}
+
+// notModified tells whether the conditional headers of the request match the
+// entry. If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, entry cacheEntry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, etag := range strings.Split(inm, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			if etag == "*" || etag == entry.etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !entry.lastModified.After(ims)
+	}
+	return false
+}
+
+// detached is a context carrying the values of its parent, but none of its
+// cancellation.
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detached) Done() <-chan struct{}               { return nil }
+func (detached) Err() error                          { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// detach returns a context with the values and the deadline of ctx, which is
+// not cancelled along with ctx.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached{ctx}, deadline)
+	}
+	return context.WithCancel(detached{ctx})
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a clock safe for use by background refreshes.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestResponseCache_FreshAndStale(t *testing.T) {
+	clock := &testClock{now: time.Date(2020, 2, 16, 12, 0, 0, 0, time.UTC)}
+	c := newResponseCache(time.Minute, 0)
+	c.now = clock.Now
+	var loads int32
+	refreshed := make(chan struct{}, 1)
+	load := func(context.Context) ([]byte, error) {
+		if atomic.AddInt32(&loads, 1) > 1 {
+			refreshed <- struct{}{}
+		}
+		return []byte(`{}`), nil
+	}
+
+	_, status, err := c.get(context.Background(), "k", time.Minute, load)
+	require.Nil(t, err)
+	assert.Equal(t, cacheMiss, status)
+	_, status, _ = c.get(context.Background(), "k", time.Minute, load)
+	assert.Equal(t, cacheHit, status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	clock.Add(90 * time.Second)
+	_, status, _ = c.get(context.Background(), "k", time.Minute, load)
+	assert.Equal(t, cacheStale, status)
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("stale entry not refreshed")
+	}
+
+	clock.Add(10 * time.Minute)
+	_, status, _ = c.get(context.Background(), "k", time.Minute, load)
+	assert.Equal(t, cacheMiss, status)
+}
+
+func TestResponseCache_SingleFlight(t *testing.T) {
+	c := newResponseCache(0, 0)
+	var loads int32
+	release := make(chan struct{})
+	load := func(context.Context) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return []byte(`{}`), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := c.get(context.Background(), "k", time.Minute, load)
+			assert.Nil(t, err)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestResponseCache_ErrorKeepsEntry(t *testing.T) {
+	c := newResponseCache(0, 0)
+	_, _, err := c.get(context.Background(), "k", 0, func(context.Context) ([]byte, error) { return []byte(`{}`), nil })
+	require.Nil(t, err)
+
+	_, _, err = c.get(context.Background(), "k", 0, func(context.Context) ([]byte, error) { return nil, errors.New("boom") })
+
+	assert.EqualError(t, err, "boom")
+	_, ok := c.peek("k")
+	assert.True(t, ok)
+}
+
+func TestResponseCache_LastModifiedFollowsContent(t *testing.T) {
+	now := time.Date(2020, 2, 16, 12, 0, 0, 0, time.UTC)
+	c := newResponseCache(0, 0)
+	c.now = func() time.Time { return now }
+	body := `{"v":1}`
+	load := func(context.Context) ([]byte, error) { return []byte(body), nil }
+
+	first, _, _ := c.get(context.Background(), "k", 0, load)
+	now = now.Add(time.Hour)
+	second, _, _ := c.get(context.Background(), "k", 0, load)
+	assert.Equal(t, first.lastModified, second.lastModified)
+	assert.Equal(t, first.etag, second.etag)
+
+	body = `{"v":2}`
+	third, _, _ := c.get(context.Background(), "k", 0, load)
+	assert.Equal(t, now, third.lastModified)
+	assert.NotEqual(t, first.etag, third.etag)
+}
+
+func TestResponseCache_Eviction(t *testing.T) {
+	now := time.Date(2020, 2, 16, 12, 0, 0, 0, time.UTC)
+	c := newResponseCache(0, 2)
+	c.now = func() time.Time { return now }
+	load := func(context.Context) ([]byte, error) { return []byte(`{}`), nil }
+
+	c.get(context.Background(), "short", time.Second, load)
+	c.get(context.Background(), "long", time.Hour, load)
+	c.get(context.Background(), "new", time.Minute, load)
+
+	_, ok := c.peek("short")
+	assert.False(t, ok)
+	_, ok = c.peek("long")
+	assert.True(t, ok)
+	_, ok = c.peek("new")
+	assert.True(t, ok)
+}
+
+func TestStations_ConditionalRequests(t *testing.T) {
+	var calls int32
+	router := mux.NewRouter()
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, stationsPayload)
+	})
+	NewRailAPI(APIConfig{StationsCacheTTLSeconds: time.Hour, CacheStaleWhileRevalidateSeconds: time.Minute}, client).AddRoute(router)
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stations", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Regexp(t, `^public, max-age=\d+, stale-while-revalidate=60$`, rec.Header().Get("Cache-Control"))
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, lastModified)
+
+	rec = get("If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Empty(t, rec.Body.String())
+
+	rec = get("If-None-Match", `"other"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = get("If-Modified-Since", lastModified)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestResponseCache_CancelledWhenAllCallersLeave(t *testing.T) {
+	c := newResponseCache(0, 0)
+	loadCancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, _, err := c.get(ctx, "k", time.Minute, func(ctx context.Context) ([]byte, error) {
+		<-ctx.Done()
+		close(loadCancelled)
+		return nil, ctx.Err()
+	})
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	select {
+	case <-loadCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("load not cancelled")
+	}
+}
+
+func TestResponseCache_LoadSurvivesOneCallerLeaving(t *testing.T) {
+	c := newResponseCache(0, 0)
+	release := make(chan struct{})
+	load := func(ctx context.Context) ([]byte, error) {
+		select {
+		case <-release:
+			return []byte(`{}`), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	leaving, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := c.get(leaving, "k", time.Minute, load)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	stayed := make(chan error)
+	go func() {
+		_, _, err := c.get(context.Background(), "k", time.Minute, load)
+		stayed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+	close(release)
+	assert.Nil(t, <-stayed)
+}
+
+func TestResponseCache_NewFlightAfterCancellation(t *testing.T) {
+	c := newResponseCache(0, 0)
+	var calls int32
+	release := make(chan struct{})
+	defer close(release)
+	load := func(ctx context.Context) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// The cancelled load lingers before returning.
+			<-release
+			return nil, ctx.Err()
+		}
+		return []byte(`{}`), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, _, err := c.get(ctx, "k", time.Minute, load)
+	require.True(t, errors.Is(err, context.Canceled))
+
+	live, cancelLive := context.WithTimeout(context.Background(), time.Second)
+	defer cancelLive()
+	entry, _, err := c.get(live, "k", time.Minute, load)
+
+	require.Nil(t, err, "the cancelled flight is not joined")
+	assert.Equal(t, []byte(`{}`), entry.body)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -1,42 +1,347 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"eurocontrol.io/demo/egress/pkg/egress"
+	"eurocontrol.io/demo/egress/pkg/logging"
+	"eurocontrol.io/demo/egress/pkg/tracing"
+	"github.com/sirupsen/logrus"
 )
 
 type RailClient struct {
-	client *http.Client
+	client     *http.Client
+	config     RailClientConfig
+	retry      retryPolicy
+	breaker    *circuitBreaker
+	validators *validatorStore
+	instrument Instrumentation
+	proxy      *egress.Proxy
+}
+
+// RailClientOption customizes the RailClient built by NewRailClient.
+type RailClientOption func(*railClientOptions)
+
+type railClientOptions struct {
+	config     RailClientConfig
+	retry      RetryConfig
+	breaker    BreakerConfig
+	transport  http.RoundTripper
+	instrument Instrumentation
+	proxy      *egress.Proxy
+}
+
+// WithConfig sets the configuration of the client, typically loaded with
+// autoconfig. Without it, the client uses the defaults of RailClientConfig.
+func WithConfig(config RailClientConfig) RailClientOption {
+	return func(o *railClientOptions) {
+		o.config = config
+	}
+}
+
+// WithRetry sets the retry policy of the client. Without it, the client uses
+// the defaults of RetryConfig.
+func WithRetry(retry RetryConfig) RailClientOption {
+	return func(o *railClientOptions) {
+		o.retry = retry
+	}
+}
+
+// WithBreaker sets the circuit breaker configuration of the client. Without
+// it, the client uses the defaults of BreakerConfig.
+func WithBreaker(breaker BreakerConfig) RailClientOption {
+	return func(o *railClientOptions) {
+		o.breaker = breaker
+	}
 }
 
+// WithTransport sets the transport used to reach upstream, for instance to
+// target a mock. The proxy of the configuration does not apply to it.
+func WithTransport(transport http.RoundTripper) RailClientOption {
+	return func(o *railClientOptions) {
+		o.transport = transport
+	}
+}
 
-func NewRailClient() RailClient {
+// WithProxy sets the egress proxy of the client, which the proxy URL of the
+// configuration overrides. Without it, the client uses the proxy of the
+// environment variables.
+func WithProxy(proxy *egress.Proxy) RailClientOption {
+	return func(o *railClientOptions) {
+		o.proxy = proxy
+	}
+}
+
+// WithInstrumentation sets the instrumentation the client, and the RailApi
+// built on it, report to. Without it, nothing is measured.
+func WithInstrumentation(instrumentation Instrumentation) RailClientOption {
+	return func(o *railClientOptions) {
+		o.instrument = instrumentation
+	}
+}
+
+// NewRailClient returns a client of the iRail API. It fails when the
+// configured proxy URL or retry policy is invalid.
+func NewRailClient(opts ...RailClientOption) (RailClient, error) {
+	o := &railClientOptions{
+		config:     DefaultRailClientConfig(),
+		retry:      DefaultRetryConfig(),
+		breaker:    DefaultBreakerConfig(),
+		instrument: nopInstrumentation{},
+		proxy:      egress.EnvironmentProxy(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	retry, err := newRetryPolicy(o.retry)
+	if err != nil {
+		return RailClient{}, err
+	}
+	proxy, err := o.proxy.Override(o.config.ProxyURL)
+	if err != nil {
+		return RailClient{}, err
+	}
+	if o.transport == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		proxy.Configure(transport)
+		o.transport = transport
+	}
+	breaker := newCircuitBreaker(o.breaker)
+	o.instrument.CircuitObserved(breaker.status)
 	return RailClient{
 		client: &http.Client{
-			Timeout: 5 *time.Second,
+			Transport: o.transport,
+			Timeout:   o.config.TimeoutMillis,
 		},
+		config:     o.config,
+		retry:      retry,
+		breaker:    breaker,
+		validators: newValidatorStore(),
+		instrument: o.instrument,
+		proxy:      proxy,
+	}, nil
+}
+
+// CircuitStatus returns the state of the circuit breaker around upstream.
+func (r RailClient) CircuitStatus() CircuitStatus {
+	return r.breaker.status()
+}
+
+// Proxy returns the egress proxy the client reaches upstream with.
+func (r RailClient) Proxy() *egress.Proxy {
+	return r.proxy
+}
+
+// Ping tells whether upstream is reachable through the egress path, proxy
+// included. It bypasses the circuit breaker and the retries, any answer of
+// upstream but a server error being fine.
+func (r RailClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, r.config.BaseURL, nil)
+	if err != nil {
+		return err
+	}
+	if r.config.UserAgent != "" {
+		req.Header.Set("User-Agent", r.config.UserAgent)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 500 {
+		return newUpstreamError(res, false)
+	}
+	return nil
+}
+
+// getData calls the given iRail endpoint and decodes the JSON response into v,
+// retrying according to the retry policy until ctx is done. It returns
+// ErrCircuitOpen without calling upstream while the circuit breaker is open.
+// Every attempt is traced with a client span and reported to the
+// instrumentation.
+func (r RailClient) getData(ctx context.Context, path string, q url.Values, v interface{}) error {
+	generation, ok := r.breaker.allow()
+	if !ok {
+		r.instrument.UpstreamCalled(path, OutcomeCircuitOpen, 0)
+		return ErrCircuitOpen
+	}
+	q.Set("format", "json")
+	if r.config.Language != "" {
+		q.Set("lang", r.config.Language)
 	}
+	target := strings.TrimSuffix(r.config.BaseURL, "/") + path + "?" + q.Encode()
+	attempt := 0
+	err := r.retry.do(ctx, func() error {
+		attempt++
+		ctx, span := tracing.Start(ctx, "GET "+path, tracing.SpanKindClient)
+		span.SetAttribute("retry.attempt", attempt)
+		start := time.Now()
+		err := r.fetch(ctx, target, v)
+		r.instrument.UpstreamCalled(path, upstreamOutcome(err), time.Since(start))
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+		return err
+	})
+	r.breaker.done(generation, err)
+	return err
 }
 
-func (r RailClient) getData(url string) (map[string]interface{},error) {
-	res,err:=r.client.Get(url)
-	if err !=nil {
-		return nil,err
+// fetch makes a single attempt of getData, forwarding the request id and the
+// trace context of ctx. The request is conditional when upstream sent
+// validators for the same URL before, the body then being decoded again from
+// the previous response on a 304.
+func (r RailClient) fetch(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
 	}
-	if res.StatusCode > 399 {
-		return nil,err
+	req.Header.Set("Accept", "application/json")
+	if r.config.UserAgent != "" {
+		req.Header.Set("User-Agent", r.config.UserAgent)
 	}
-	v:=make(map[string]interface{})
-	err = json.NewDecoder(res.Body).Decode(&v)
-	if err !=nil {
-		return nil,err
+	tracing.Inject(ctx, req.Header)
+	previous, conditional := r.validators.prepare(req)
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", target)
+	span.SetAttribute("net.peer.name", req.URL.Hostname())
+	log := logging.FromContext(ctx).WithField("upstream_url", target)
+	start := time.Now()
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
 	}
-	return v,nil
+	defer res.Body.Close()
+	span.SetAttribute("http.status_code", res.StatusCode)
+	log.WithFields(logrus.Fields{
+		"upstream_status":     res.StatusCode,
+		"upstream_latency_ms": time.Since(start).Milliseconds(),
+	}).Debug("upstream called")
+	var body []byte
+	switch {
+	case res.StatusCode == http.StatusNotModified && conditional:
+		body = previous.body
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return newUpstreamError(res, r.retry.isRetryableStatus(res.StatusCode))
+	default:
+		body, err = io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("invalid payload from %s: %v", req.URL, err)
+	}
+	if res.StatusCode != http.StatusNotModified {
+		r.validators.remember(req, res, body)
+	}
+	return nil
 }
 
-func (r RailClient) GetStations() (map[string]interface{},error) {
-	url:="https://api.irail.be/stations/?format=json"
-	return r.getData(url)
+// GetStations returns the list of stations known by iRail.
+func (r RailClient) GetStations(ctx context.Context) (StationList, error) {
+	var s irailStations
+	err := r.getData(ctx, "/stations/", url.Values{}, &s)
+	if err != nil {
+		return StationList{}, err
+	}
+	return s.toStationList(), nil
 }
 
+// GetLiveboard returns the departures or arrivals of the given station around
+// the given time. A zero time means now.
+func (r RailClient) GetLiveboard(ctx context.Context, stationID string, arrdep Direction, at time.Time) (Liveboard, error) {
+	q := url.Values{}
+	q.Set("id", stationID)
+	switch arrdep {
+	case Departures:
+		q.Set("arrdep", "departure")
+	case Arrivals:
+		q.Set("arrdep", "arrival")
+	default:
+		return Liveboard{}, fmt.Errorf("unsupported liveboard direction: %s", arrdep)
+	}
+	if !at.IsZero() {
+		date, hour := irailDateTime(at)
+		q.Set("date", date)
+		q.Set("time", hour)
+	}
+	var l irailLiveboard
+	err := r.getData(ctx, "/liveboard/", q, &l)
+	if err != nil {
+		return Liveboard{}, err
+	}
+	return l.toLiveboard(arrdep), nil
+}
+
+// GetConnections returns the connections between two stations, leaving or
+// arriving around the given time depending on timesel. A zero time means now.
+func (r RailClient) GetConnections(ctx context.Context, from, to string, at time.Time, timesel TimeSelection) (ConnectionList, error) {
+	if timesel != DepartAt && timesel != ArriveBy {
+		return ConnectionList{}, fmt.Errorf("unsupported time selection: %s", timesel)
+	}
+	q := url.Values{}
+	q.Set("from", from)
+	q.Set("to", to)
+	q.Set("timesel", string(timesel))
+	if !at.IsZero() {
+		date, hour := irailDateTime(at)
+		q.Set("date", date)
+		q.Set("time", hour)
+	}
+	var c irailConnections
+	err := r.getData(ctx, "/connections/", q, &c)
+	if err != nil {
+		return ConnectionList{}, err
+	}
+	return c.toConnectionList(), nil
+}
+
+// GetVehicle returns the stops of the given vehicle on the given day.
+// A zero date means today.
+func (r RailClient) GetVehicle(ctx context.Context, id string, date time.Time) (VehicleJourney, error) {
+	q := url.Values{}
+	q.Set("id", id)
+	if !date.IsZero() {
+		day, _ := irailDateTime(date)
+		q.Set("date", day)
+	}
+	var v irailVehicle
+	err := r.getData(ctx, "/vehicle/", q, &v)
+	if err != nil {
+		return VehicleJourney{}, err
+	}
+	return v.toVehicleJourney(), nil
+}
+
+// GetComposition returns the carriages of the given vehicle.
+func (r RailClient) GetComposition(ctx context.Context, id string) (Composition, error) {
+	q := url.Values{}
+	q.Set("id", id)
+	var c irailComposition
+	err := r.getData(ctx, "/composition/", q, &c)
+	if err != nil {
+		return Composition{}, err
+	}
+	return c.toComposition(id), nil
+}
+
+// GetDisturbances returns the current disturbances and planned works.
+func (r RailClient) GetDisturbances(ctx context.Context) (DisturbanceList, error) {
+	var d irailDisturbances
+	err := r.getData(ctx, "/disturbances/", url.Values{}, &d)
+	if err != nil {
+		return DisturbanceList{}, err
+	}
+	return d.toDisturbanceList(), nil
+}
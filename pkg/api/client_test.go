@@ -1,15 +1,219 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/egress"
+	"eurocontrol.io/demo/egress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// newTestClient returns a client of the given handler, served as a local
+// iRail mock. Retries are spaced by a few milliseconds only.
+func newTestClient(t *testing.T, handler http.HandlerFunc) RailClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	config := DefaultRailClientConfig()
+	config.BaseURL = server.URL
+	retry := DefaultRetryConfig()
+	retry.BaseBackoffMillis = time.Millisecond
+	retry.MaxBackoffMillis = 10 * time.Millisecond
+	client, err := NewRailClient(WithConfig(config), WithRetry(retry))
+	require.Nil(t, err)
+	return client
+}
+
 func TestNewRailClient(t *testing.T) {
-	client:=NewRailClient()
-	stations,err:=client.GetStations()
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/stations/", r.URL.Path)
+		assert.Equal(t, "json", r.URL.Query().Get("format"))
+		assert.Equal(t, "en", r.URL.Query().Get("lang"))
+		assert.Equal(t, "demo-egress-http", r.Header.Get("User-Agent"))
+		fmt.Fprint(w, stationsPayload)
+	})
+	stations, err := client.GetStations(context.Background())
+	require.Nil(t, err)
+	require.Len(t, stations.Stations, 1)
+	assert.Equal(t, "BE.NMBS.008892007", stations.Stations[0].ID)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewRailClient_WithTransport(t *testing.T) {
+	var called string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		called = r.URL.String()
+		rec := httptest.NewRecorder()
+		fmt.Fprint(rec, liveboardPayload)
+		return rec.Result(), nil
+	})
+	config := DefaultRailClientConfig()
+	config.BaseURL = "http://mirror.local/"
+	config.Language = "fr"
+	client, err := NewRailClient(WithConfig(config), WithTransport(transport))
+	require.Nil(t, err)
+
+	at := time.Date(2020, 2, 16, 12, 30, 0, 0, time.UTC)
+	board, err := client.GetLiveboard(context.Background(), "BE.NMBS.008892007", Departures, at)
+
+	require.Nil(t, err)
+	assert.Len(t, board.Entries, 1)
+	assert.Equal(t, "http://mirror.local/liveboard/?arrdep=departure&date=160220&format=json&id=BE.NMBS.008892007&lang=fr&time=1330", called)
+}
+
+func TestNewRailClient_InvalidProxy(t *testing.T) {
+	config := DefaultRailClientConfig()
+	config.ProxyURL = "http://[::1"
+	_, err := NewRailClient(WithConfig(config))
+	assert.Error(t, err)
+}
+
+func TestGetData_UpstreamError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "station not found", http.StatusNotFound)
+	})
+	_, err := client.GetLiveboard(context.Background(), "unknown", Departures, time.Time{})
+
+	var upstreamErr *UpstreamError
+	require.True(t, errors.As(err, &upstreamErr))
+	assert.Equal(t, http.StatusNotFound, upstreamErr.StatusCode)
+	assert.Equal(t, "station not found", upstreamErr.Body)
+	assert.Contains(t, upstreamErr.URL, "/liveboard/?")
+	assert.False(t, upstreamErr.Retryable)
+}
+
+func TestGetData_UpstreamErrorBodyExcerpt(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, strings.Repeat("x", 2*maxBodyExcerpt))
+	})
+	_, err := client.GetStations(context.Background())
+
+	var upstreamErr *UpstreamError
+	require.True(t, errors.As(err, &upstreamErr))
+	assert.Len(t, upstreamErr.Body, maxBodyExcerpt)
+	assert.True(t, upstreamErr.Retryable)
+}
+
+func TestGetData_ContextCancellation(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(upstreamCancelled)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err := client.GetStations(ctx)
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request not cancelled")
+	}
+	assert.Equal(t, CircuitClosed, client.CircuitStatus().State)
+}
+
+func TestGetData_ForwardsTraceContext(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "abc-123", r.Header.Get(tracing.RequestIDHeader))
+		tc, err := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader))
+		require.Nil(t, err)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+		assert.NotEqual(t, "00f067aa0ba902b7", tc.ParentID, "the client span is the parent of the call")
+		assert.True(t, tc.Sampled())
+		assert.Equal(t, "congo=t61rcWkgMzE", r.Header.Get(tracing.TracestateHeader))
+		fmt.Fprint(w, stationsPayload)
+	})
+	ctx := tracing.WithRequestID(context.Background(), "abc-123")
+	ctx = tracing.WithTraceContext(ctx, tracing.TraceContext{
+		TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		ParentID: "00f067aa0ba902b7",
+		Flags:    1,
+		State:    "congo=t61rcWkgMzE",
+	})
+
+	_, err := client.GetStations(ctx)
+
+	require.Nil(t, err)
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		upstream int
+		ok       bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNotFound, true},
+		{http.StatusBadGateway, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.upstream), func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodHead, r.Method)
+				w.WriteHeader(tt.upstream)
+			})
+
+			err := client.Ping(context.Background())
+
+			assert.Equal(t, tt.ok, err == nil, fmt.Sprint(err))
+		})
+	}
+}
+
+func TestGetData_ProxyRefused(t *testing.T) {
+	connects := 0
+	squid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connects++
+		assert.Equal(t, http.MethodConnect, r.Method)
+		assert.Equal(t, "api.irail.be:443", r.Host)
+		http.Error(w, "access denied", http.StatusForbidden)
+	}))
+	t.Cleanup(squid.Close)
+	proxy, err := egress.NewProxy(egress.ProxyConfig{URL: squid.URL})
+	require.Nil(t, err)
+	client, err := NewRailClient(WithProxy(proxy))
+	require.Nil(t, err)
+
+	_, err = client.GetStations(context.Background())
+
+	var refused *egress.ProxyRefusedError
+	require.True(t, errors.As(err, &refused), err)
+	assert.Equal(t, http.StatusForbidden, refused.StatusCode)
+	assert.Equal(t, 1, connects, "a refusal is not retried")
+	assert.Equal(t, OutcomeProxyRefused, upstreamOutcome(err))
+}
+
+func TestNewRailClient_ProxyOverride(t *testing.T) {
+	proxy, err := egress.NewProxy(egress.ProxyConfig{URL: "http://squid:3128"})
+	require.Nil(t, err)
+	config := DefaultRailClientConfig()
+
+	client, err := NewRailClient(WithConfig(config), WithProxy(proxy))
 	require.Nil(t, err)
-	fmt.Println(stations)
+	assert.Equal(t, "http://squid:3128", client.Proxy().String())
 
+	config.ProxyURL = egress.Direct
+	client, err = NewRailClient(WithConfig(config), WithProxy(proxy))
+	require.Nil(t, err)
+	assert.Equal(t, egress.Direct, client.Proxy().String())
+
+	config.ProxyURL = "http://other:8080"
+	client, err = NewRailClient(WithConfig(config), WithProxy(proxy))
+	require.Nil(t, err)
+	assert.Equal(t, "http://other:8080", client.Proxy().String())
 }
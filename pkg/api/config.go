@@ -0,0 +1,48 @@
+package api
+
+import "time"
+
+// APIConfig is the configuration of the rail API routes. Responses are cached
+// for the time to live of their route, 0 disabling the cache, and can be
+// served stale for CacheStaleWhileRevalidateSeconds while being refreshed.
+// A CacheMaxEntries of 0 does not bound the cache. The timeout of a route
+// bounds its calls to upstream, retries included, 0 meaning no timeout. A
+// disturbance poll interval that is not positive polls every minute.
+type APIConfig struct {
+	DisturbancesPollIntervalSeconds  time.Duration `value:"rail.api.disturbances.poll-interval|60" validate:"min=1"`
+	StationsCacheTTLSeconds          time.Duration `value:"rail.api.cache.stations-ttl|3600"`
+	LiveboardCacheTTLSeconds         time.Duration `value:"rail.api.cache.liveboard-ttl|30"`
+	ConnectionsCacheTTLSeconds       time.Duration `value:"rail.api.cache.connections-ttl|60"`
+	VehicleCacheTTLSeconds           time.Duration `value:"rail.api.cache.vehicle-ttl|30"`
+	CompositionCacheTTLSeconds       time.Duration `value:"rail.api.cache.composition-ttl|600"`
+	DisturbancesCacheTTLSeconds      time.Duration `value:"rail.api.cache.disturbances-ttl|60"`
+	CacheStaleWhileRevalidateSeconds time.Duration `value:"rail.api.cache.stale-while-revalidate|60"`
+	CacheMaxEntries                  int           `value:"rail.api.cache.max-entries|1024"`
+	StationsTimeoutMillis            time.Duration `value:"rail.api.timeout.stations|8000"`
+	LiveboardTimeoutMillis           time.Duration `value:"rail.api.timeout.liveboard|8000"`
+	ConnectionsTimeoutMillis         time.Duration `value:"rail.api.timeout.connections|8000"`
+	VehicleTimeoutMillis             time.Duration `value:"rail.api.timeout.vehicle|8000"`
+	CompositionTimeoutMillis         time.Duration `value:"rail.api.timeout.composition|8000"`
+	DisturbancesTimeoutMillis        time.Duration `value:"rail.api.timeout.disturbances|8000"`
+}
+
+// RailClientConfig is the configuration of the iRail client. The proxy URL
+// overrides the egress proxy for iRail: empty keeps it, "direct" disables it.
+type RailClientConfig struct {
+	BaseURL       string        `value:"rail.client.base-url|https://api.irail.be" validate:"required,url"`
+	TimeoutMillis time.Duration `value:"rail.client.timeout|5000"`
+	ProxyURL      string        `value:"rail.client.proxy-url"`
+	UserAgent     string        `value:"rail.client.user-agent|demo-egress-http"`
+	Language      string        `value:"rail.client.language|en"`
+}
+
+// DefaultRailClientConfig returns the configuration used when none is given
+// to NewRailClient. It matches the defaults of the value tags.
+func DefaultRailClientConfig() RailClientConfig {
+	return RailClientConfig{
+		BaseURL:       "https://api.irail.be",
+		TimeoutMillis: 5 * time.Second,
+		UserAgent:     "demo-egress-http",
+		Language:      "en",
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/autoconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetConfig forgets the configuration files and the viper properties, now
+// and at the end of the test, the environment variables being left untouched.
+func resetConfig(t *testing.T) {
+	autoconfig.Reset()
+	t.Cleanup(autoconfig.Reset)
+}
+
+// setEnv sets the environment variable for the duration of the test.
+func setEnv(t *testing.T, key, value string) {
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestDefaultRailClientConfig_MatchesTags(t *testing.T) {
+	resetConfig(t)
+	config := RailClientConfig{}
+	require.Nil(t, autoconfig.AutoConfigure(&config))
+	assert.Equal(t, DefaultRailClientConfig(), config)
+}
+
+func TestDefaultRetryConfig_MatchesTags(t *testing.T) {
+	resetConfig(t)
+	config := RetryConfig{}
+	require.Nil(t, autoconfig.AutoConfigure(&config))
+	assert.Equal(t, DefaultRetryConfig(), config)
+}
+
+func TestDefaultBreakerConfig_MatchesTags(t *testing.T) {
+	resetConfig(t)
+	config := BreakerConfig{}
+	require.Nil(t, autoconfig.AutoConfigure(&config))
+	assert.Equal(t, DefaultBreakerConfig(), config)
+}
+
+func TestAPIConfig_Err_PollInterval(t *testing.T) {
+	resetConfig(t)
+	setEnv(t, "RAIL_API_DISTURBANCES_POLLINTERVAL", "0")
+
+	err := autoconfig.AutoConfigure(&APIConfig{})
+
+	assert.EqualError(t, err, "rail.api.disturbances.poll-interval (RAIL_API_DISTURBANCES_POLLINTERVAL) from environment: must be at least 1s")
+}
+
+func TestAPIConfig_Err_PollInterval_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "application.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("rail.api.disturbances.poll-interval: 30\n"), 0600))
+	resetConfig(t)
+	setEnv(t, autoconfig.ConfigPathEnv, dir)
+	config := &APIConfig{}
+	w, err := autoconfig.Watch(config, func(interface{}, []autoconfig.Change) {
+		t.Error("invalid configuration notified")
+	}, autoconfig.WithDebounce(time.Hour))
+	require.NoError(t, err)
+	defer w.Close()
+	require.Equal(t, 30*time.Second, config.DisturbancesPollIntervalSeconds)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("rail.api.disturbances.poll-interval: 0\n"), 0600))
+
+	assert.EqualError(t, w.Reload(), "rail.api.disturbances.poll-interval (RAIL_API_DISTURBANCES_POLLINTERVAL) from file "+path+": must be at least 1s")
+	assert.Same(t, config, w.Current())
+}
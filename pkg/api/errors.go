@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBodyExcerpt is the number of bytes of an upstream error body kept in
+// UpstreamError.
+const maxBodyExcerpt = 512
+
+// UpstreamError is returned by RailClient when upstream answers with a
+// non-2xx status. RetryAfter is set when upstream sent a Retry-After header.
+type UpstreamError struct {
+	StatusCode int
+	URL        string
+	Body       string
+	Retryable  bool
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("upstream %s returned %d", e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("upstream %s returned %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// newUpstreamError builds the error of the given response, reading at most
+// maxBodyExcerpt bytes of its body.
+func newUpstreamError(res *http.Response, retryable bool) *UpstreamError {
+	excerpt, _ := io.ReadAll(io.LimitReader(res.Body, maxBodyExcerpt))
+	return &UpstreamError{
+		StatusCode: res.StatusCode,
+		URL:        res.Request.URL.String(),
+		Body:       strings.TrimSpace(string(excerpt)),
+		Retryable:  retryable,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// gatewayStatus returns the status served by the rail API when a call to
+// upstream fails with err. A missing resource upstream is still a missing
+// resource for the caller, any other failure is reported as a gateway error,
+// an open circuit breaker being reported as unavailable.
+func gatewayStatus(err error) int {
+	if errors.Is(err, ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case http.StatusNotFound:
+			return http.StatusNotFound
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return http.StatusServiceUnavailable
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	}
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// isTimeout tells whether err is a deadline exceeded, of the context or of
+// the network.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/egress"
+	"eurocontrol.io/demo/egress/pkg/metrics"
+)
+
+// Instrumentation is told about what the rail API and its client do, to
+// measure them. A RailClient reports to the instrumentation given with
+// WithInstrumentation, and the RailApi built on the client to the same one.
+// Every route added by AddRoute is reported, so new routes are measured as
+// soon as they are added.
+type Instrumentation interface {
+	// RouteStarted is called when a route starts serving a request, and
+	// RouteServed once it has served it.
+	RouteStarted(route string)
+	RouteServed(route string, status int, latency time.Duration)
+	// CacheLookedUp is called with the cache status, HIT, MISS or STALE, of
+	// every response served from the response cache.
+	CacheLookedUp(route string, status string)
+	// UpstreamCalled is called after every attempt to call an upstream
+	// endpoint, and when the circuit breaker rejects a call.
+	UpstreamCalled(endpoint string, outcome UpstreamOutcome, latency time.Duration)
+	// CircuitObserved is given the status of the circuit breaker of a new
+	// client, to be read whenever it is measured.
+	CircuitObserved(status func() CircuitStatus)
+}
+
+// UpstreamOutcome classifies the result of a call to upstream.
+type UpstreamOutcome string
+
+const (
+	OutcomeSuccess     UpstreamOutcome = "success"
+	OutcomeClientError UpstreamOutcome = "client_error"
+	OutcomeServerError UpstreamOutcome = "server_error"
+	OutcomeTimeout     UpstreamOutcome = "timeout"
+	OutcomeCanceled    UpstreamOutcome = "canceled"
+	OutcomeError       UpstreamOutcome = "error"
+	OutcomeCircuitOpen UpstreamOutcome = "circuit_open"
+	// The egress proxy refused the tunnel or could not be reached.
+	OutcomeProxyRefused     UpstreamOutcome = "proxy_refused"
+	OutcomeProxyUnreachable UpstreamOutcome = "proxy_unreachable"
+)
+
+func upstreamOutcome(err error) UpstreamOutcome {
+	var upstreamErr *UpstreamError
+	var refused *egress.ProxyRefusedError
+	var unreachable *egress.ProxyUnreachableError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrCircuitOpen):
+		return OutcomeCircuitOpen
+	case errors.As(err, &upstreamErr) && upstreamErr.StatusCode < 500:
+		return OutcomeClientError
+	case errors.As(err, &upstreamErr):
+		return OutcomeServerError
+	case errors.As(err, &refused):
+		return OutcomeProxyRefused
+	case errors.As(err, &unreachable):
+		return OutcomeProxyUnreachable
+	case errors.Is(err, context.Canceled):
+		return OutcomeCanceled
+	case isTimeout(err):
+		return OutcomeTimeout
+	default:
+		return OutcomeError
+	}
+}
+
+type nopInstrumentation struct{}
+
+func (nopInstrumentation) RouteStarted(string)                                   {}
+func (nopInstrumentation) RouteServed(string, int, time.Duration)                {}
+func (nopInstrumentation) CacheLookedUp(string, string)                          {}
+func (nopInstrumentation) UpstreamCalled(string, UpstreamOutcome, time.Duration) {}
+func (nopInstrumentation) CircuitObserved(func() CircuitStatus)                  {}
+
+// Metrics is the instrumentation exposing the measures as Prometheus metrics.
+type Metrics struct {
+	requests         *metrics.Counter
+	requestDuration  *metrics.Histogram
+	inFlight         *metrics.Gauge
+	upstreamRequests *metrics.Counter
+	upstreamDuration *metrics.Histogram
+	cacheLookups     *metrics.Counter
+
+	// hits and lookups make the cache hit ratio, updated atomically.
+	hits    uint64
+	lookups uint64
+
+	mu       sync.Mutex
+	circuits []func() CircuitStatus
+}
+
+// NewMetrics registers the metrics of the rail API in the given registry. The
+// cache hit ratio counts the stale responses as hits, upstream not being
+// waited for. The circuit state is the one of the most open circuit breaker
+// among the clients reporting to the metrics.
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	m := &Metrics{
+		requests: registry.Counter("http_requests_total",
+			"Requests served, by route and status.", "route", "status"),
+		requestDuration: registry.Histogram("http_request_duration_seconds",
+			"Latency of the requests served, by route and status.", metrics.DefaultBuckets, "route", "status"),
+		inFlight: registry.Gauge("http_requests_in_flight",
+			"Requests being served."),
+		upstreamRequests: registry.Counter("rail_upstream_requests_total",
+			"Calls to upstream, retries included, by endpoint and outcome.", "endpoint", "outcome"),
+		upstreamDuration: registry.Histogram("rail_upstream_request_duration_seconds",
+			"Latency of the calls to upstream, by endpoint and outcome.", metrics.DefaultBuckets, "endpoint", "outcome"),
+		cacheLookups: registry.Counter("rail_cache_lookups_total",
+			"Responses served from the response cache, by route and cache status.", "route", "status"),
+	}
+	registry.GaugeFunc("rail_cache_hit_ratio",
+		"Ratio of the responses served from the response cache without calling upstream.",
+		func() float64 {
+			lookups := atomic.LoadUint64(&m.lookups)
+			if lookups == 0 {
+				return 0
+			}
+			return float64(atomic.LoadUint64(&m.hits)) / float64(lookups)
+		})
+	registry.GaugeFunc("rail_circuit_state",
+		"State of the circuit breaker around upstream: 0 closed, 1 half-open, 2 open.",
+		m.circuitState)
+	return m
+}
+
+func (m *Metrics) RouteStarted(route string) {
+	m.inFlight.Add(1)
+}
+
+func (m *Metrics) RouteServed(route string, status int, latency time.Duration) {
+	m.inFlight.Add(-1)
+	m.requests.Inc(route, strconv.Itoa(status))
+	m.requestDuration.Observe(latency.Seconds(), route, strconv.Itoa(status))
+}
+
+func (m *Metrics) CacheLookedUp(route string, status string) {
+	m.cacheLookups.Inc(route, status)
+	atomic.AddUint64(&m.lookups, 1)
+	if status != string(cacheMiss) {
+		atomic.AddUint64(&m.hits, 1)
+	}
+}
+
+func (m *Metrics) UpstreamCalled(endpoint string, outcome UpstreamOutcome, latency time.Duration) {
+	m.upstreamRequests.Inc(endpoint, string(outcome))
+	if outcome != OutcomeCircuitOpen {
+		m.upstreamDuration.Observe(latency.Seconds(), endpoint, string(outcome))
+	}
+}
+
+func (m *Metrics) CircuitObserved(status func() CircuitStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.circuits = append(m.circuits, status)
+}
+
+func (m *Metrics) circuitState() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := 0.0
+	for _, status := range m.circuits {
+		switch status().State {
+		case CircuitHalfOpen:
+			state = math.Max(state, 1)
+		case CircuitOpen:
+			state = 2
+		}
+	}
+	return state
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/metrics"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want UpstreamOutcome
+	}{
+		{nil, OutcomeSuccess},
+		{ErrCircuitOpen, OutcomeCircuitOpen},
+		{&UpstreamError{StatusCode: http.StatusNotFound}, OutcomeClientError},
+		{&UpstreamError{StatusCode: http.StatusServiceUnavailable}, OutcomeServerError},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), OutcomeTimeout},
+		{context.Canceled, OutcomeCanceled},
+		{errors.New("connection refused"), OutcomeError},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, upstreamOutcome(tt.err), fmt.Sprint(tt.err))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, stationsPayload)
+	}))
+	t.Cleanup(upstream.Close)
+	config := DefaultRailClientConfig()
+	config.BaseURL = upstream.URL
+	client, err := NewRailClient(
+		WithConfig(config),
+		WithRetry(RetryConfig{MaxAttempts: 3, BaseBackoffMillis: time.Millisecond, MaxBackoffMillis: 10 * time.Millisecond, RetryableStatuses: []string{"502"}}),
+		WithInstrumentation(NewMetrics(registry)),
+	)
+	require.Nil(t, err)
+	router := mux.NewRouter()
+	NewRailAPI(APIConfig{StationsCacheTTLSeconds: time.Minute}, client).AddRoute(router)
+
+	for _, target := range []string{"/stations", "/stations", "/stations/008892007/liveboard?direction=up"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	var out strings.Builder
+	registry.WriteText(&out)
+	text := out.String()
+
+	assert.Contains(t, text, `http_requests_total{route="/stations",status="200"} 2`)
+	assert.Contains(t, text, `http_requests_total{route="/stations/{id}/liveboard",status="400"} 1`)
+	assert.Contains(t, text, `http_request_duration_seconds_count{route="/stations",status="200"} 2`)
+	assert.Contains(t, text, "http_requests_in_flight 0\n")
+	assert.Contains(t, text, `rail_upstream_requests_total{endpoint="/stations/",outcome="server_error"} 1`)
+	assert.Contains(t, text, `rail_upstream_requests_total{endpoint="/stations/",outcome="success"} 1`)
+	assert.Contains(t, text, `rail_upstream_request_duration_seconds_count{endpoint="/stations/",outcome="success"} 1`)
+	assert.Contains(t, text, `rail_cache_lookups_total{route="/stations",status="HIT"} 1`)
+	assert.Contains(t, text, `rail_cache_lookups_total{route="/stations",status="MISS"} 1`)
+	assert.Contains(t, text, "rail_cache_hit_ratio 0.5\n")
+	assert.Contains(t, text, "rail_circuit_state 0\n")
+}
+
+func TestMetrics_SharedByClients(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry)
+	state := func() string {
+		var out strings.Builder
+		registry.WriteText(&out)
+		return out.String()
+	}
+	assert.Contains(t, state(), "rail_circuit_state 0\n", "closed without client")
+
+	_, err := NewRailClient(WithInstrumentation(m))
+	require.Nil(t, err)
+	client, err := NewRailClient(WithBreaker(BreakerConfig{FailureThreshold: 1, CoolDownSeconds: time.Minute, HalfOpenMaxCalls: 1}), WithInstrumentation(m))
+	require.Nil(t, err)
+	assert.Contains(t, state(), "rail_circuit_state 0\n")
+
+	client.breaker.done(0, errors.New("connection refused"))
+	assert.Contains(t, state(), "rail_circuit_state 2\n", "most open circuit reported")
+}
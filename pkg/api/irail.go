@@ -0,0 +1,487 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+	// iRail dates and times are local to Belgium, whatever the container timezone.
+	_ "time/tzdata"
+)
+
+// The iRail API encodes every scalar as a string. The types below mirror the
+// upstream payload and are converted to the types of model.go so that the
+// schema served by the rail API does not depend on what upstream sends.
+
+type irailStation struct {
+	ID           string `json:"id"`
+	URI          string `json:"@id"`
+	Name         string `json:"name"`
+	StandardName string `json:"standardname"`
+	LocationX    string `json:"locationX"`
+	LocationY    string `json:"locationY"`
+}
+
+type irailStations struct {
+	Version   string         `json:"version"`
+	Timestamp string         `json:"timestamp"`
+	Station   []irailStation `json:"station"`
+}
+
+func (s irailStation) toStation() Station {
+	return Station{
+		ID:           s.ID,
+		Name:         s.Name,
+		StandardName: s.StandardName,
+		Location: Location{
+			Longitude: parseFloat(s.LocationX),
+			Latitude:  parseFloat(s.LocationY),
+		},
+		URI: s.URI,
+	}
+}
+
+func (s irailStations) toStationList() StationList {
+	stations := make([]Station, 0, len(s.Station))
+	for _, st := range s.Station {
+		stations = append(stations, st.toStation())
+	}
+	return StationList{
+		Version:   s.Version,
+		Timestamp: parseUnix(s.Timestamp),
+		Stations:  stations,
+	}
+}
+
+type irailVehicleInfo struct {
+	Name      string `json:"name"`
+	ShortName string `json:"shortname"`
+	Number    string `json:"number"`
+	Type      string `json:"type"`
+	URI       string `json:"@id"`
+}
+
+type irailPlatformInfo struct {
+	Name   string `json:"name"`
+	Normal string `json:"normal"`
+}
+
+type irailLiveboardEntry struct {
+	Station      string            `json:"station"`
+	StationInfo  irailStation      `json:"stationinfo"`
+	Time         string            `json:"time"`
+	Delay        string            `json:"delay"`
+	Canceled     string            `json:"canceled"`
+	Left         string            `json:"left"`
+	Arrived      string            `json:"arrived"`
+	IsExtra      string            `json:"isExtra"`
+	Vehicle      string            `json:"vehicle"`
+	VehicleInfo  irailVehicleInfo  `json:"vehicleinfo"`
+	Platform     string            `json:"platform"`
+	PlatformInfo irailPlatformInfo `json:"platforminfo"`
+}
+
+type irailLiveboard struct {
+	Version     string       `json:"version"`
+	Timestamp   string       `json:"timestamp"`
+	StationInfo irailStation `json:"stationinfo"`
+	Departures  struct {
+		Departure []irailLiveboardEntry `json:"departure"`
+	} `json:"departures"`
+	Arrivals struct {
+		Arrival []irailLiveboardEntry `json:"arrival"`
+	} `json:"arrivals"`
+}
+
+func (v irailVehicleInfo) toVehicle(id string) Vehicle {
+	if v.Name != "" {
+		id = v.Name
+	}
+	return Vehicle{
+		ID:        id,
+		ShortName: v.ShortName,
+		Type:      v.Type,
+		Number:    v.Number,
+		URI:       v.URI,
+	}
+}
+
+func (p irailPlatformInfo) toPlatform(name string) Platform {
+	if p.Name != "" {
+		name = p.Name
+	}
+	return Platform{Name: name, Normal: p.Normal != "0"}
+}
+
+func (e irailLiveboardEntry) toLiveboardEntry() LiveboardEntry {
+	return LiveboardEntry{
+		Station:      e.StationInfo.toStation(),
+		Time:         parseUnix(e.Time),
+		DelaySeconds: parseInt(e.Delay),
+		Platform:     e.PlatformInfo.toPlatform(e.Platform),
+		Canceled:     parseBool(e.Canceled),
+		Passed:       parseBool(e.Left) || parseBool(e.Arrived),
+		Extra:        parseBool(e.IsExtra),
+		Vehicle:      e.VehicleInfo.toVehicle(e.Vehicle),
+	}
+}
+
+func (l irailLiveboard) toLiveboard(direction Direction) Liveboard {
+	upstream := l.Departures.Departure
+	if direction == Arrivals {
+		upstream = l.Arrivals.Arrival
+	}
+	entries := make([]LiveboardEntry, 0, len(upstream))
+	for _, e := range upstream {
+		entries = append(entries, e.toLiveboardEntry())
+	}
+	return Liveboard{
+		Version:   l.Version,
+		Timestamp: parseUnix(l.Timestamp),
+		Station:   l.StationInfo.toStation(),
+		Direction: direction,
+		Entries:   entries,
+	}
+}
+
+type irailDirection struct {
+	Name string `json:"name"`
+}
+
+type irailConnectionStop struct {
+	StationInfo  irailStation      `json:"stationinfo"`
+	Time         string            `json:"time"`
+	Delay        string            `json:"delay"`
+	Canceled     string            `json:"canceled"`
+	Walking      string            `json:"walking"`
+	Vehicle      string            `json:"vehicle"`
+	VehicleInfo  irailVehicleInfo  `json:"vehicleinfo"`
+	Platform     string            `json:"platform"`
+	PlatformInfo irailPlatformInfo `json:"platforminfo"`
+	Direction    irailDirection    `json:"direction"`
+}
+
+type irailVia struct {
+	StationInfo irailStation        `json:"stationinfo"`
+	Arrival     irailConnectionStop `json:"arrival"`
+	Departure   irailConnectionStop `json:"departure"`
+	TimeBetween string              `json:"timeBetween"`
+}
+
+type irailConnection struct {
+	Departure irailConnectionStop `json:"departure"`
+	Arrival   irailConnectionStop `json:"arrival"`
+	Duration  string              `json:"duration"`
+	Vias      struct {
+		Via []irailVia `json:"via"`
+	} `json:"vias"`
+}
+
+type irailConnections struct {
+	Version    string            `json:"version"`
+	Timestamp  string            `json:"timestamp"`
+	Connection []irailConnection `json:"connection"`
+}
+
+// toStop converts the stop, the station of the vias being given apart from
+// their arrival and departure.
+func (s irailConnectionStop) toStop(station irailStation) Stop {
+	return Stop{
+		Station:      station.toStation(),
+		Time:         parseUnix(s.Time),
+		DelaySeconds: parseInt(s.Delay),
+		Platform:     s.PlatformInfo.toPlatform(s.Platform),
+		Canceled:     parseBool(s.Canceled),
+	}
+}
+
+func (s irailConnectionStop) toLeg(departure Stop, arrival Stop) Leg {
+	return Leg{
+		Departure: departure,
+		Arrival:   arrival,
+		Vehicle:   s.VehicleInfo.toVehicle(s.Vehicle),
+		Direction: s.Direction.Name,
+		Walking:   parseBool(s.Walking),
+	}
+}
+
+// toConnection converts the connection and splits it into legs: one from the
+// departure to the first via, one between each via and one from the last via
+// to the arrival.
+func (c irailConnection) toConnection() Connection {
+	conn := Connection{
+		Departure:       c.Departure.toStop(c.Departure.StationInfo),
+		Arrival:         c.Arrival.toStop(c.Arrival.StationInfo),
+		DurationSeconds: parseInt(c.Duration),
+		Transfers:       len(c.Vias.Via),
+		Vias:            make([]Via, 0, len(c.Vias.Via)),
+		Legs:            make([]Leg, 0, len(c.Vias.Via)+1),
+	}
+	from, start := c.Departure, conn.Departure
+	for _, v := range c.Vias.Via {
+		via := Via{
+			Station:            v.StationInfo.toStation(),
+			Arrival:            v.Arrival.toStop(v.StationInfo),
+			Departure:          v.Departure.toStop(v.StationInfo),
+			TimeBetweenSeconds: parseInt(v.TimeBetween),
+		}
+		conn.Vias = append(conn.Vias, via)
+		conn.Legs = append(conn.Legs, from.toLeg(start, via.Arrival))
+		from, start = v.Departure, via.Departure
+	}
+	conn.Legs = append(conn.Legs, from.toLeg(start, conn.Arrival))
+	return conn
+}
+
+func (c irailConnections) toConnectionList() ConnectionList {
+	connections := make([]Connection, 0, len(c.Connection))
+	for _, conn := range c.Connection {
+		connections = append(connections, conn.toConnection())
+	}
+	return ConnectionList{
+		Version:     c.Version,
+		Timestamp:   parseUnix(c.Timestamp),
+		Connections: connections,
+	}
+}
+
+type irailVehicleStop struct {
+	StationInfo            irailStation      `json:"stationinfo"`
+	Platform               string            `json:"platform"`
+	PlatformInfo           irailPlatformInfo `json:"platforminfo"`
+	ScheduledArrivalTime   string            `json:"scheduledArrivalTime"`
+	ScheduledDepartureTime string            `json:"scheduledDepartureTime"`
+	ArrivalDelay           string            `json:"arrivalDelay"`
+	DepartureDelay         string            `json:"departureDelay"`
+	ArrivalCanceled        string            `json:"arrivalCanceled"`
+	DepartureCanceled      string            `json:"departureCanceled"`
+	Arrived                string            `json:"arrived"`
+	Left                   string            `json:"left"`
+	IsExtraStop            string            `json:"isExtraStop"`
+}
+
+type irailVehicle struct {
+	Version     string           `json:"version"`
+	Timestamp   string           `json:"timestamp"`
+	Vehicle     string           `json:"vehicle"`
+	VehicleInfo irailVehicleInfo `json:"vehicleinfo"`
+	Stops       struct {
+		Stop []irailVehicleStop `json:"stop"`
+	} `json:"stops"`
+}
+
+func (s irailVehicleStop) toVehicleStop() VehicleStop {
+	stop := VehicleStop{
+		Station:               s.StationInfo.toStation(),
+		Platform:              s.PlatformInfo.toPlatform(s.Platform),
+		ScheduledArrival:      parseUnix(s.ScheduledArrivalTime),
+		ScheduledDeparture:    parseUnix(s.ScheduledDepartureTime),
+		ArrivalDelaySeconds:   parseInt(s.ArrivalDelay),
+		DepartureDelaySeconds: parseInt(s.DepartureDelay),
+		ArrivalCanceled:       parseBool(s.ArrivalCanceled),
+		DepartureCanceled:     parseBool(s.DepartureCanceled),
+		Arrived:               parseBool(s.Arrived),
+		Left:                  parseBool(s.Left),
+		Extra:                 parseBool(s.IsExtraStop),
+	}
+	if !stop.ScheduledArrival.IsZero() {
+		stop.ActualArrival = stop.ScheduledArrival.Add(time.Duration(stop.ArrivalDelaySeconds) * time.Second)
+	}
+	if !stop.ScheduledDeparture.IsZero() {
+		stop.ActualDeparture = stop.ScheduledDeparture.Add(time.Duration(stop.DepartureDelaySeconds) * time.Second)
+	}
+	return stop
+}
+
+func (v irailVehicle) toVehicleJourney() VehicleJourney {
+	stops := make([]VehicleStop, 0, len(v.Stops.Stop))
+	for _, s := range v.Stops.Stop {
+		stops = append(stops, s.toVehicleStop())
+	}
+	return VehicleJourney{
+		Version:   v.Version,
+		Timestamp: parseUnix(v.Timestamp),
+		Vehicle:   v.VehicleInfo.toVehicle(v.Vehicle),
+		Stops:     stops,
+	}
+}
+
+// irailScalar accepts the strings, numbers and booleans the composition
+// endpoint mixes for the same fields.
+type irailScalar string
+
+func (s *irailScalar) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case nil:
+		*s = ""
+	case string:
+		*s = irailScalar(t)
+	case bool:
+		*s = "0"
+		if t {
+			*s = "1"
+		}
+	default:
+		*s = irailScalar(strconv.FormatFloat(t.(float64), 'f', -1, 64))
+	}
+	return nil
+}
+
+type irailUnit struct {
+	MaterialType struct {
+		ParentType  irailScalar `json:"parent_type"`
+		SubType     irailScalar `json:"sub_type"`
+		Orientation irailScalar `json:"orientation"`
+	} `json:"materialType"`
+	MaterialNumber   irailScalar `json:"materialNumber"`
+	SeatsFirstClass  irailScalar `json:"seatsFirstClass"`
+	SeatsSecondClass irailScalar `json:"seatsSecondClass"`
+	HasToilets       irailScalar `json:"hasToilets"`
+	HasAirco         irailScalar `json:"hasAirco"`
+	HasBikeSection   irailScalar `json:"hasBikeSection"`
+	HasPrmSection    irailScalar `json:"hasPrmSection"`
+}
+
+type irailSegment struct {
+	Origin      irailStation `json:"origin"`
+	Destination irailStation `json:"destination"`
+	Composition struct {
+		Source irailScalar `json:"source"`
+		Units  struct {
+			Unit []irailUnit `json:"unit"`
+		} `json:"units"`
+	} `json:"composition"`
+}
+
+type irailComposition struct {
+	Version     string `json:"version"`
+	Timestamp   string `json:"timestamp"`
+	Composition struct {
+		Segments struct {
+			Segment []irailSegment `json:"segment"`
+		} `json:"segments"`
+	} `json:"composition"`
+}
+
+func (u irailUnit) toCarriage() Carriage {
+	return Carriage{
+		MaterialType:     string(u.MaterialType.ParentType),
+		MaterialSubType:  string(u.MaterialType.SubType),
+		MaterialNumber:   string(u.MaterialNumber),
+		Orientation:      string(u.MaterialType.Orientation),
+		SeatsFirstClass:  parseInt(string(u.SeatsFirstClass)),
+		SeatsSecondClass: parseInt(string(u.SeatsSecondClass)),
+		HasToilets:       parseBool(string(u.HasToilets)),
+		HasAirco:         parseBool(string(u.HasAirco)),
+		HasBikeSection:   parseBool(string(u.HasBikeSection)),
+		HasPrmSection:    parseBool(string(u.HasPrmSection)),
+	}
+}
+
+func (c irailComposition) toComposition(vehicleID string) Composition {
+	segments := make([]CompositionSegment, 0, len(c.Composition.Segments.Segment))
+	for _, s := range c.Composition.Segments.Segment {
+		carriages := make([]Carriage, 0, len(s.Composition.Units.Unit))
+		for _, u := range s.Composition.Units.Unit {
+			carriages = append(carriages, u.toCarriage())
+		}
+		segments = append(segments, CompositionSegment{
+			Origin:      s.Origin.toStation(),
+			Destination: s.Destination.toStation(),
+			Source:      string(s.Composition.Source),
+			Carriages:   carriages,
+		})
+	}
+	return Composition{
+		Version:   c.Version,
+		Timestamp: parseUnix(c.Timestamp),
+		VehicleID: vehicleID,
+		Segments:  segments,
+	}
+}
+
+type irailDisturbance struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Link        string `json:"link"`
+	Timestamp   string `json:"timestamp"`
+}
+
+type irailDisturbances struct {
+	Version     string             `json:"version"`
+	Timestamp   string             `json:"timestamp"`
+	Disturbance []irailDisturbance `json:"disturbance"`
+}
+
+// toDisturbance converts the disturbance. iRail ids are positions in the
+// list, so the link, or the title when there is none, identifies it instead.
+func (d irailDisturbance) toDisturbance() Disturbance {
+	id := d.Link
+	if id == "" {
+		id = d.Title
+	}
+	return Disturbance{
+		ID:          id,
+		Title:       d.Title,
+		Description: d.Description,
+		Type:        d.Type,
+		Link:        d.Link,
+		Timestamp:   parseUnix(d.Timestamp),
+	}
+}
+
+func (d irailDisturbances) toDisturbanceList() DisturbanceList {
+	disturbances := make([]Disturbance, 0, len(d.Disturbance))
+	for _, dist := range d.Disturbance {
+		disturbances = append(disturbances, dist.toDisturbance())
+	}
+	return DisturbanceList{
+		Version:      d.Version,
+		Timestamp:    parseUnix(d.Timestamp),
+		Disturbances: disturbances,
+	}
+}
+
+// irailLocation is the timezone of the dates and times sent to iRail.
+var irailLocation = mustLoadLocation("Europe/Brussels")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// irailDateTime formats t as the date (ddmmyy) and time (HHMM) query
+// parameters expected by iRail.
+func irailDateTime(t time.Time) (string, string) {
+	t = t.In(irailLocation)
+	return t.Format("020106"), t.Format("1504")
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func parseBool(s string) bool {
+	return s == "1" || s == "true"
+}
+
+func parseUnix(s string) time.Time {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stationsPayload = `{
+  "version": "1.1",
+  "timestamp": "1581856899",
+  "station": [
+    {
+      "@id": "http://irail.be/stations/NMBS/008892007",
+      "id": "BE.NMBS.008892007",
+      "name": "Ghent-Sint-Pieters",
+      "locationX": "3.710675",
+      "locationY": "51.035896",
+      "standardname": "Gent-Sint-Pieters"
+    }
+  ]
+}`
+
+func TestToStationList(t *testing.T) {
+	var s irailStations
+	require.Nil(t, json.Unmarshal([]byte(stationsPayload), &s))
+
+	list := s.toStationList()
+
+	assert.Equal(t, "1.1", list.Version)
+	assert.Equal(t, time.Unix(1581856899, 0).UTC(), list.Timestamp)
+	require.Len(t, list.Stations, 1)
+	assert.Equal(t, Station{
+		ID:           "BE.NMBS.008892007",
+		Name:         "Ghent-Sint-Pieters",
+		StandardName: "Gent-Sint-Pieters",
+		Location:     Location{Longitude: 3.710675, Latitude: 51.035896},
+		URI:          "http://irail.be/stations/NMBS/008892007",
+	}, list.Stations[0])
+}
+
+const liveboardPayload = `{
+  "version": "1.1",
+  "timestamp": "1581856899",
+  "station": "Ghent-Sint-Pieters",
+  "stationinfo": {"id": "BE.NMBS.008892007", "name": "Ghent-Sint-Pieters", "standardname": "Gent-Sint-Pieters"},
+  "departures": {
+    "number": "1",
+    "departure": [
+      {
+        "id": "0",
+        "delay": "120",
+        "station": "Antwerp-Central",
+        "stationinfo": {"id": "BE.NMBS.008821006", "name": "Antwerp-Central", "standardname": "Antwerpen-Centraal"},
+        "time": "1581856980",
+        "vehicle": "BE.NMBS.EC9272",
+        "vehicleinfo": {"name": "BE.NMBS.EC9272", "shortname": "EC 9272", "number": "9272", "type": "EC", "@id": "http://irail.be/vehicle/EC9272"},
+        "platform": "4",
+        "platforminfo": {"name": "4", "normal": "0"},
+        "canceled": "1",
+        "left": "0",
+        "isExtra": "0"
+      }
+    ]
+  }
+}`
+
+func TestToLiveboard(t *testing.T) {
+	var l irailLiveboard
+	require.Nil(t, json.Unmarshal([]byte(liveboardPayload), &l))
+
+	board := l.toLiveboard(Departures)
+
+	assert.Equal(t, "BE.NMBS.008892007", board.Station.ID)
+	assert.Equal(t, Departures, board.Direction)
+	require.Len(t, board.Entries, 1)
+	e := board.Entries[0]
+	assert.Equal(t, "Antwerp-Central", e.Station.Name)
+	assert.Equal(t, time.Unix(1581856980, 0).UTC(), e.Time)
+	assert.Equal(t, 120, e.DelaySeconds)
+	assert.Equal(t, Platform{Name: "4", Normal: false}, e.Platform)
+	assert.True(t, e.Canceled)
+	assert.False(t, e.Passed)
+	assert.Equal(t, "BE.NMBS.EC9272", e.Vehicle.ID)
+	assert.Equal(t, "EC", e.Vehicle.Type)
+
+	assert.Empty(t, l.toLiveboard(Arrivals).Entries)
+}
+
+func TestIrailDateTime(t *testing.T) {
+	date, hour := irailDateTime(time.Date(2020, 2, 16, 12, 30, 0, 0, time.UTC))
+	assert.Equal(t, "160220", date)
+	assert.Equal(t, "1330", hour)
+}
+
+const connectionsPayload = `{
+  "version": "1.1",
+  "timestamp": "1581856899",
+  "connection": [
+    {
+      "id": "0",
+      "departure": {
+        "delay": "60",
+        "stationinfo": {"id": "BE.NMBS.008892007", "name": "Ghent-Sint-Pieters"},
+        "time": "1581857000",
+        "vehicle": "BE.NMBS.IC1832",
+        "vehicleinfo": {"name": "BE.NMBS.IC1832", "type": "IC", "number": "1832"},
+        "platform": "4",
+        "platforminfo": {"name": "4", "normal": "1"},
+        "canceled": "0",
+        "direction": {"name": "Eupen"},
+        "walking": "0"
+      },
+      "arrival": {
+        "delay": "300",
+        "stationinfo": {"id": "BE.NMBS.008863008", "name": "Namur"},
+        "time": "1581862000",
+        "vehicle": "BE.NMBS.IC2136",
+        "platform": "2",
+        "platforminfo": {"name": "2", "normal": "1"},
+        "canceled": "0",
+        "direction": {"name": "Luxembourg"},
+        "walking": "0"
+      },
+      "duration": "5000",
+      "vias": {
+        "number": "1",
+        "via": [
+          {
+            "id": "0",
+            "arrival": {"time": "1581859000", "delay": "120", "platform": "12", "vehicle": "BE.NMBS.IC1832", "direction": {"name": "Eupen"}},
+            "departure": {"time": "1581859600", "delay": "0", "platform": "15", "vehicle": "BE.NMBS.IC2136", "direction": {"name": "Luxembourg"}},
+            "timeBetween": "600",
+            "stationinfo": {"id": "BE.NMBS.008813003", "name": "Brussels-Central"}
+          }
+        ]
+      }
+    }
+  ]
+}`
+
+func TestToConnectionList(t *testing.T) {
+	var c irailConnections
+	require.Nil(t, json.Unmarshal([]byte(connectionsPayload), &c))
+
+	list := c.toConnectionList()
+
+	require.Len(t, list.Connections, 1)
+	conn := list.Connections[0]
+	assert.Equal(t, 5000, conn.DurationSeconds)
+	assert.Equal(t, 1, conn.Transfers)
+	require.Len(t, conn.Vias, 1)
+	assert.Equal(t, "Brussels-Central", conn.Vias[0].Station.Name)
+	assert.Equal(t, 600, conn.Vias[0].TimeBetweenSeconds)
+
+	require.Len(t, conn.Legs, 2)
+	first, second := conn.Legs[0], conn.Legs[1]
+	assert.Equal(t, "Ghent-Sint-Pieters", first.Departure.Station.Name)
+	assert.Equal(t, 60, first.Departure.DelaySeconds)
+	assert.Equal(t, "Brussels-Central", first.Arrival.Station.Name)
+	assert.Equal(t, 120, first.Arrival.DelaySeconds)
+	assert.Equal(t, "BE.NMBS.IC1832", first.Vehicle.ID)
+	assert.Equal(t, "Eupen", first.Direction)
+	assert.Equal(t, "Brussels-Central", second.Departure.Station.Name)
+	assert.Equal(t, "15", second.Departure.Platform.Name)
+	assert.Equal(t, "Namur", second.Arrival.Station.Name)
+	assert.Equal(t, 300, second.Arrival.DelaySeconds)
+	assert.Equal(t, "BE.NMBS.IC2136", second.Vehicle.ID)
+}
+
+const vehiclePayload = `{
+  "version": "1.1",
+  "timestamp": "1581856899",
+  "vehicle": "BE.NMBS.IC1832",
+  "vehicleinfo": {"name": "BE.NMBS.IC1832", "shortname": "IC 1832", "type": "IC", "number": "1832"},
+  "stops": {
+    "number": "2",
+    "stop": [
+      {
+        "stationinfo": {"id": "BE.NMBS.008892007", "name": "Ghent-Sint-Pieters"},
+        "platform": "4",
+        "platforminfo": {"name": "4", "normal": "1"},
+        "scheduledArrivalTime": "0",
+        "scheduledDepartureTime": "1581857000",
+        "departureDelay": "60",
+        "arrivalDelay": "0",
+        "left": "1"
+      },
+      {
+        "stationinfo": {"id": "BE.NMBS.008813003", "name": "Brussels-Central"},
+        "platform": "3",
+        "platforminfo": {"name": "3", "normal": "1"},
+        "scheduledArrivalTime": "1581859000",
+        "scheduledDepartureTime": "1581859120",
+        "arrivalDelay": "120",
+        "departureDelay": "120",
+        "arrivalCanceled": "0",
+        "departureCanceled": "1"
+      }
+    ]
+  }
+}`
+
+func TestToVehicleJourney(t *testing.T) {
+	var v irailVehicle
+	require.Nil(t, json.Unmarshal([]byte(vehiclePayload), &v))
+
+	journey := v.toVehicleJourney()
+
+	assert.Equal(t, "BE.NMBS.IC1832", journey.Vehicle.ID)
+	require.Len(t, journey.Stops, 2)
+	first, second := journey.Stops[0], journey.Stops[1]
+	assert.True(t, first.ScheduledArrival.IsZero())
+	assert.True(t, first.ActualArrival.IsZero())
+	assert.Equal(t, time.Unix(1581857060, 0).UTC(), first.ActualDeparture)
+	assert.True(t, first.Left)
+	assert.Equal(t, time.Unix(1581859000, 0).UTC(), second.ScheduledArrival)
+	assert.Equal(t, time.Unix(1581859120, 0).UTC(), second.ActualArrival)
+	assert.Equal(t, 120, second.DepartureDelaySeconds)
+	assert.True(t, second.DepartureCanceled)
+}
+
+const compositionPayload = `{
+  "version": "1.1",
+  "timestamp": "1581856899",
+  "composition": {
+    "segments": {
+      "number": "1",
+      "segment": [
+        {
+          "origin": {"id": "BE.NMBS.008892007", "name": "Ghent-Sint-Pieters"},
+          "destination": {"id": "BE.NMBS.008813003", "name": "Brussels-Central"},
+          "composition": {
+            "source": "Atlas",
+            "units": {
+              "number": "1",
+              "unit": [
+                {
+                  "materialType": {"parent_type": "AM08", "sub_type": "C", "orientation": "LEFT"},
+                  "materialNumber": 8011,
+                  "seatsFirstClass": "22",
+                  "seatsSecondClass": 56,
+                  "hasToilets": true,
+                  "hasAirco": "1",
+                  "hasBikeSection": false,
+                  "hasPrmSection": "0"
+                }
+              ]
+            }
+          }
+        }
+      ]
+    }
+  }
+}`
+
+func TestToComposition(t *testing.T) {
+	var c irailComposition
+	require.Nil(t, json.Unmarshal([]byte(compositionPayload), &c))
+
+	composition := c.toComposition("IC1832")
+
+	assert.Equal(t, "IC1832", composition.VehicleID)
+	require.Len(t, composition.Segments, 1)
+	segment := composition.Segments[0]
+	assert.Equal(t, "Ghent-Sint-Pieters", segment.Origin.Name)
+	assert.Equal(t, "Atlas", segment.Source)
+	require.Len(t, segment.Carriages, 1)
+	assert.Equal(t, Carriage{
+		MaterialType:     "AM08",
+		MaterialSubType:  "C",
+		MaterialNumber:   "8011",
+		Orientation:      "LEFT",
+		SeatsFirstClass:  22,
+		SeatsSecondClass: 56,
+		HasToilets:       true,
+		HasAirco:         true,
+	}, segment.Carriages[0])
+}
+
+const disturbancesPayload = `{
+  "version": "1.1",
+  "timestamp": "1581856899",
+  "disturbance": [
+    {"id": "0", "title": "Strike", "description": "No trains", "type": "disturbance", "link": "http://www.belgianrail.be/1", "timestamp": "1581856000"},
+    {"id": "1", "title": "Works", "type": "planned", "timestamp": "1581855000"}
+  ]
+}`
+
+func TestToDisturbanceList(t *testing.T) {
+	var d irailDisturbances
+	require.Nil(t, json.Unmarshal([]byte(disturbancesPayload), &d))
+
+	list := d.toDisturbanceList()
+
+	require.Len(t, list.Disturbances, 2)
+	assert.Equal(t, "http://www.belgianrail.be/1", list.Disturbances[0].ID)
+	assert.Equal(t, "disturbance", list.Disturbances[0].Type)
+	assert.Equal(t, "Works", list.Disturbances[1].ID)
+	assert.Equal(t, time.Unix(1581855000, 0).UTC(), list.Disturbances[1].Timestamp)
+}
@@ -0,0 +1,198 @@
+package api
+
+import "time"
+
+// Location is the geographical position of a station.
+type Location struct {
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+// Station is a railway station as served by the rail API.
+type Station struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	StandardName string   `json:"standardName"`
+	Location     Location `json:"location"`
+	URI          string   `json:"uri"`
+}
+
+// StationList is the envelope returned for the list of stations.
+type StationList struct {
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Stations  []Station `json:"stations"`
+}
+
+// Direction selects the trains listed on a liveboard.
+type Direction string
+
+const (
+	Departures Direction = "departures"
+	Arrivals   Direction = "arrivals"
+)
+
+// Vehicle identifies the train serving a departure or an arrival.
+type Vehicle struct {
+	ID        string `json:"id"`
+	ShortName string `json:"shortName"`
+	Type      string `json:"type"`
+	Number    string `json:"number"`
+	URI       string `json:"uri"`
+}
+
+// Platform is the platform a train stops at. Normal is false when the
+// platform differs from the scheduled one.
+type Platform struct {
+	Name   string `json:"name"`
+	Normal bool   `json:"normal"`
+}
+
+// LiveboardEntry is a train leaving or reaching the liveboard station.
+// Station is the destination for departures and the origin for arrivals,
+// Passed tells whether the train has already left or arrived.
+type LiveboardEntry struct {
+	Station      Station   `json:"station"`
+	Time         time.Time `json:"time"`
+	DelaySeconds int       `json:"delaySeconds"`
+	Platform     Platform  `json:"platform"`
+	Canceled     bool      `json:"canceled"`
+	Passed       bool      `json:"passed"`
+	Extra        bool      `json:"extra"`
+	Vehicle      Vehicle   `json:"vehicle"`
+}
+
+// Liveboard lists the departures or arrivals of a station.
+type Liveboard struct {
+	Version   string           `json:"version"`
+	Timestamp time.Time        `json:"timestamp"`
+	Station   Station          `json:"station"`
+	Direction Direction        `json:"direction"`
+	Entries   []LiveboardEntry `json:"entries"`
+}
+
+// TimeSelection tells whether the time of a journey search is the departure
+// or the arrival time.
+type TimeSelection string
+
+const (
+	DepartAt TimeSelection = "departure"
+	ArriveBy TimeSelection = "arrival"
+)
+
+// Stop is a train passing at a station, either at the start or at the end
+// of a leg.
+type Stop struct {
+	Station      Station   `json:"station"`
+	Time         time.Time `json:"time"`
+	DelaySeconds int       `json:"delaySeconds"`
+	Platform     Platform  `json:"platform"`
+	Canceled     bool      `json:"canceled"`
+}
+
+// Leg is the part of a connection travelled in a single vehicle.
+type Leg struct {
+	Departure Stop    `json:"departure"`
+	Arrival   Stop    `json:"arrival"`
+	Vehicle   Vehicle `json:"vehicle"`
+	Direction string  `json:"direction"`
+	Walking   bool    `json:"walking"`
+}
+
+// Via is a station where the traveller changes trains.
+type Via struct {
+	Station            Station `json:"station"`
+	Arrival            Stop    `json:"arrival"`
+	Departure          Stop    `json:"departure"`
+	TimeBetweenSeconds int     `json:"timeBetweenSeconds"`
+}
+
+// Connection is a journey between two stations.
+type Connection struct {
+	Departure       Stop  `json:"departure"`
+	Arrival         Stop  `json:"arrival"`
+	DurationSeconds int   `json:"durationSeconds"`
+	Transfers       int   `json:"transfers"`
+	Vias            []Via `json:"vias"`
+	Legs            []Leg `json:"legs"`
+}
+
+// ConnectionList is the envelope returned for a journey search.
+type ConnectionList struct {
+	Version     string       `json:"version"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Connections []Connection `json:"connections"`
+}
+
+// VehicleStop is a station served by a vehicle. Actual times are the
+// scheduled times shifted by the delays.
+type VehicleStop struct {
+	Station               Station   `json:"station"`
+	Platform              Platform  `json:"platform"`
+	ScheduledArrival      time.Time `json:"scheduledArrival"`
+	ScheduledDeparture    time.Time `json:"scheduledDeparture"`
+	ActualArrival         time.Time `json:"actualArrival"`
+	ActualDeparture       time.Time `json:"actualDeparture"`
+	ArrivalDelaySeconds   int       `json:"arrivalDelaySeconds"`
+	DepartureDelaySeconds int       `json:"departureDelaySeconds"`
+	ArrivalCanceled       bool      `json:"arrivalCanceled"`
+	DepartureCanceled     bool      `json:"departureCanceled"`
+	Arrived               bool      `json:"arrived"`
+	Left                  bool      `json:"left"`
+	Extra                 bool      `json:"extra"`
+}
+
+// VehicleJourney is the list of stops of a vehicle on a given day.
+type VehicleJourney struct {
+	Version   string        `json:"version"`
+	Timestamp time.Time     `json:"timestamp"`
+	Vehicle   Vehicle       `json:"vehicle"`
+	Stops     []VehicleStop `json:"stops"`
+}
+
+// Carriage is a unit of a train composition.
+type Carriage struct {
+	MaterialType     string `json:"materialType"`
+	MaterialSubType  string `json:"materialSubType"`
+	MaterialNumber   string `json:"materialNumber"`
+	Orientation      string `json:"orientation"`
+	SeatsFirstClass  int    `json:"seatsFirstClass"`
+	SeatsSecondClass int    `json:"seatsSecondClass"`
+	HasToilets       bool   `json:"hasToilets"`
+	HasAirco         bool   `json:"hasAirco"`
+	HasBikeSection   bool   `json:"hasBikeSection"`
+	HasPrmSection    bool   `json:"hasPrmSection"`
+}
+
+// CompositionSegment is the composition of a vehicle between two stations.
+type CompositionSegment struct {
+	Origin      Station    `json:"origin"`
+	Destination Station    `json:"destination"`
+	Source      string     `json:"source"`
+	Carriages   []Carriage `json:"carriages"`
+}
+
+// Composition lists the carriages of a vehicle, segment by segment.
+type Composition struct {
+	Version   string               `json:"version"`
+	Timestamp time.Time            `json:"timestamp"`
+	VehicleID string               `json:"vehicleId"`
+	Segments  []CompositionSegment `json:"segments"`
+}
+
+// Disturbance is an incident or a planned work on the network.
+type Disturbance struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Type        string    `json:"type"`
+	Link        string    `json:"link"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// DisturbanceList is the envelope returned for the current disturbances.
+type DisturbanceList struct {
+	Version      string        `json:"version"`
+	Timestamp    time.Time     `json:"timestamp"`
+	Disturbances []Disturbance `json:"disturbances"`
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/egress"
+	"eurocontrol.io/demo/egress/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// RetryConfig is the retry policy of the iRail client. Attempts are spaced by
+// an exponential backoff starting at BaseBackoffMillis and capped at
+// MaxBackoffMillis, from which up to JitterPercent percent is randomly
+// removed. Only transport errors and the RetryableStatuses are retried.
+type RetryConfig struct {
+	MaxAttempts       int           `value:"rail.client.retry.max-attempts|3" validate:"min=1"`
+	BaseBackoffMillis time.Duration `value:"rail.client.retry.base-backoff|100"`
+	MaxBackoffMillis  time.Duration `value:"rail.client.retry.max-backoff|2000"`
+	JitterPercent     int           `value:"rail.client.retry.jitter-percent|50" validate:"min=0,max=100"`
+	RetryableStatuses []string      `value:"rail.client.retry.statuses|408 429 500 502 503 504" validate:"regex=^[1-5][0-9][0-9]$"`
+}
+
+// DefaultRetryConfig returns the retry policy used when none is given to
+// NewRailClient. It matches the defaults of the value tags.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:       3,
+		BaseBackoffMillis: 100 * time.Millisecond,
+		MaxBackoffMillis:  2 * time.Second,
+		JitterPercent:     50,
+		RetryableStatuses: []string{"408", "429", "500", "502", "503", "504"},
+	}
+}
+
+type retryPolicy struct {
+	config   RetryConfig
+	statuses map[int]bool
+	random   func() float64
+}
+
+func newRetryPolicy(config RetryConfig) (retryPolicy, error) {
+	if config.MaxAttempts < 1 {
+		return retryPolicy{}, fmt.Errorf("invalid retry max attempts %d, at least 1 expected", config.MaxAttempts)
+	}
+	if config.JitterPercent < 0 || config.JitterPercent > 100 {
+		return retryPolicy{}, fmt.Errorf("invalid retry jitter %d, percentage expected", config.JitterPercent)
+	}
+	statuses := make(map[int]bool, len(config.RetryableStatuses))
+	for _, s := range config.RetryableStatuses {
+		status, err := strconv.Atoi(s)
+		if err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid retryable status %s: %v", s, err)
+		}
+		statuses[status] = true
+	}
+	return retryPolicy{config: config, statuses: statuses, random: rand.Float64}, nil
+}
+
+func (p retryPolicy) isRetryableStatus(status int) bool {
+	return p.statuses[status]
+}
+
+// backoff returns the delay before the given retry, the first retry being 1.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.config.BaseBackoffMillis
+	for i := 1; i < retry && d < p.config.MaxBackoffMillis; i++ {
+		d *= 2
+	}
+	if d > p.config.MaxBackoffMillis {
+		d = p.config.MaxBackoffMillis
+	}
+	jitter := float64(d) * float64(p.config.JitterPercent) / 100 * p.random()
+	return d - time.Duration(jitter)
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable or
+// runs out of attempts. It never waits beyond the deadline of ctx: when the
+// next attempt cannot start in time, the last error is returned right away.
+// A Retry-After longer than the maximum backoff is not waited for either.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.config.MaxAttempts || !p.isRetryable(ctx, err) {
+			return err
+		}
+		wait := p.backoff(attempt)
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > 0 {
+			if upstreamErr.RetryAfter > p.config.MaxBackoffMillis {
+				return err
+			}
+			wait = upstreamErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		logging.FromContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"wait_ms": wait.Milliseconds(),
+		}).Info("upstream call failed, retrying")
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (p retryPolicy) isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Retryable
+	}
+	var refused *egress.ProxyRefusedError
+	if errors.As(err, &refused) {
+		return refused.Retryable()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as
+// an HTTP date. It returns 0 when the header is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
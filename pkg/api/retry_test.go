@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry_TransientFailures(t *testing.T) {
+	var calls int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, stationsPayload)
+	})
+
+	stations, err := client.GetStations(context.Background())
+
+	require.Nil(t, err)
+	assert.Len(t, stations.Stations, 1)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	var calls int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := client.GetStations(context.Background())
+
+	var upstreamErr *UpstreamError
+	require.True(t, errors.As(err, &upstreamErr))
+	assert.Equal(t, http.StatusBadGateway, upstreamErr.StatusCode)
+	assert.Equal(t, int32(DefaultRetryConfig().MaxAttempts), atomic.LoadInt32(&calls))
+}
+
+func TestRetry_NotRetryableStatus(t *testing.T) {
+	var calls int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := client.GetStations(context.Background())
+
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetry_RetryAfterBeyondMaxBackoff(t *testing.T) {
+	var calls int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	_, err := client.GetStations(context.Background())
+
+	var upstreamErr *UpstreamError
+	require.True(t, errors.As(err, &upstreamErr))
+	assert.Equal(t, 120*time.Second, upstreamErr.RetryAfter)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetry_ContextDeadline(t *testing.T) {
+	config := DefaultRetryConfig()
+	config.BaseBackoffMillis = time.Second
+	policy, err := newRetryPolicy(config)
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err = policy.do(ctx, func() error {
+		calls++
+		return &UpstreamError{StatusCode: http.StatusServiceUnavailable, Retryable: true}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+}
+
+func TestRetry_Backoff(t *testing.T) {
+	config := DefaultRetryConfig()
+	config.JitterPercent = 0
+	policy, err := newRetryPolicy(config)
+	require.Nil(t, err)
+
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, 2*time.Second, policy.backoff(10))
+
+	policy.config.JitterPercent = 50
+	policy.random = func() float64 { return 1 }
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(1))
+}
+
+func TestRetry_InvalidConfig(t *testing.T) {
+	config := DefaultRetryConfig()
+	config.RetryableStatuses = []string{"503", "oops"}
+	_, err := NewRailClient(WithRetry(config))
+	assert.EqualError(t, err, `invalid retryable status oops: strconv.Atoi: parsing "oops": invalid syntax`)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 2, 16, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Sun, 16 Feb 2020 12:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Sun, 16 Feb 2020 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
@@ -1,41 +1,376 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"eurocontrol.io/demo/egress/pkg/logging"
+	"eurocontrol.io/demo/egress/pkg/tracing"
 	"github.com/gorilla/mux"
 )
 
 type RailApi interface {
 	AddRoute(router *mux.Router)
+	// Shutdown ends the disturbance streams, which would otherwise keep the
+	// server from shutting down.
+	Shutdown()
+	// Reconfigure applies the cache time to live and the timeouts of the
+	// given configuration to the next requests. The disturbance poll interval
+	// and the cache bounds keep their initial value.
+	Reconfigure(config APIConfig)
 }
 
-
 type railAPI struct {
-	client RailClient
+	mu           sync.RWMutex
+	config       APIConfig
+	client       RailClient
+	disturbances *disturbanceFeed
+	cache        *responseCache
+}
+
+func NewRailAPI(config APIConfig, client RailClient) RailApi {
+	return &railAPI{
+		config:       config,
+		client:       client,
+		disturbances: newDisturbanceFeed(client, config.DisturbancesPollIntervalSeconds),
+		cache:        newResponseCache(config.CacheStaleWhileRevalidateSeconds, config.CacheMaxEntries),
+	}
+}
+
+func (ra *railAPI) stations() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := ra.currentConfig()
+		ra.serveCached(w, r, config.StationsCacheTTLSeconds, config.StationsTimeoutMillis, func(ctx context.Context) (interface{}, error) {
+			return ra.client.GetStations(ctx)
+		})
+	}
+}
+
+func (ra *railAPI) liveboard() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		direction := Direction(r.URL.Query().Get("direction"))
+		if direction == "" {
+			direction = Departures
+		}
+		if direction != Departures && direction != Arrivals {
+			writeProblem(w, http.StatusBadRequest, "direction must be departures or arrivals")
+			return
+		}
+		at, err := parseTime(r.URL.Query().Get("at"))
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		config := ra.currentConfig()
+		ra.serveCached(w, r, config.LiveboardCacheTTLSeconds, config.LiveboardTimeoutMillis, func(ctx context.Context) (interface{}, error) {
+			return ra.client.GetLiveboard(ctx, id, direction, at)
+		})
+	}
+}
+
+func (ra *railAPI) connections() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, to := q.Get("from"), q.Get("to")
+		if from == "" || to == "" {
+			writeProblem(w, http.StatusBadRequest, "from and to are required")
+			return
+		}
+		timesel := TimeSelection(q.Get("timesel"))
+		if timesel == "" {
+			timesel = DepartAt
+		}
+		if timesel != DepartAt && timesel != ArriveBy {
+			writeProblem(w, http.StatusBadRequest, "timesel must be departure or arrival")
+			return
+		}
+		at, err := parseDateTime(q.Get("date"), q.Get("time"))
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		config := ra.currentConfig()
+		ra.serveCached(w, r, config.ConnectionsCacheTTLSeconds, config.ConnectionsTimeoutMillis, func(ctx context.Context) (interface{}, error) {
+			return ra.client.GetConnections(ctx, from, to, at, timesel)
+		})
+	}
 }
 
+func (ra *railAPI) vehicle() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		date, err := parseDate(r.URL.Query().Get("date"))
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		config := ra.currentConfig()
+		ra.serveCached(w, r, config.VehicleCacheTTLSeconds, config.VehicleTimeoutMillis, func(ctx context.Context) (interface{}, error) {
+			return ra.client.GetVehicle(ctx, id, date)
+		})
+	}
+}
 
-func NewRailAPI() RailApi {
-	return &railAPI{NewRailClient()}
+func (ra *railAPI) composition() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		config := ra.currentConfig()
+		ra.serveCached(w, r, config.CompositionCacheTTLSeconds, config.CompositionTimeoutMillis, func(ctx context.Context) (interface{}, error) {
+			return ra.client.GetComposition(ctx, id)
+		})
+	}
 }
 
-func (ra *railAPI) stations() func(w http.ResponseWriter, r *http.Request){
+func (ra *railAPI) disturbanceList() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("GET STATIONS")
-		s,err:=ra.client.GetStations()
-		if err !=nil {
-			fmt.Printf("error %v \n",err)
-			http.Error(w, err.Error(),
-				http.StatusInternalServerError)
+		config := ra.currentConfig()
+		ra.serveCached(w, r, config.DisturbancesCacheTTLSeconds, config.DisturbancesTimeoutMillis, func(ctx context.Context) (interface{}, error) {
+			return ra.client.GetDisturbances(ctx)
+		})
+	}
+}
+
+// disturbanceStream pushes the new and changed disturbances as Server-Sent
+// Events, one "disturbance" event per disturbance, until the client leaves, it
+// is too slow or the API shuts down. A comment is sent when nothing happened
+// for a while to keep the connection open.
+func (ra *railAPI) disturbanceStream() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeProblem(w, http.StatusInternalServerError, "streaming unsupported")
+			return
+		}
+		ch, current := ra.disturbances.subscribe()
+		defer ra.disturbances.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(ra.disturbances.heartbeat)
+		defer heartbeat.Stop()
+		for {
+			for _, d := range current {
+				data, err := json.Marshal(d)
+				if err != nil {
+					logging.FromContext(r.Context()).WithError(err).Error("disturbance not encoded")
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: disturbance\ndata: %s\n\n", strings.ReplaceAll(d.ID, "\n", " "), data)
+			}
+			flusher.Flush()
+			current = nil
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ":\n\n")
+			case changed, ok := <-ch:
+				if !ok {
+					return
+				}
+				current = changed
+			}
+		}
+	}
+}
+
+// parseDate parses the optional date (2006-01-02) query parameter, local to
+// Belgium.
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", date, irailLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, 2006-01-02 expected", date)
+	}
+	return t, nil
+}
+
+// parseDateTime parses the optional date (2006-01-02) and time (15:04) query
+// parameters, both local to Belgium. A missing date means today and a missing
+// time means now.
+func parseDateTime(date, clock string) (time.Time, error) {
+	if date == "" && clock == "" {
+		return time.Time{}, nil
+	}
+	now := time.Now().In(irailLocation)
+	if date == "" {
+		date = now.Format("2006-01-02")
+	}
+	if clock == "" {
+		clock = now.Format("15:04")
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04", date+" "+clock, irailLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q or time %q, 2006-01-02 and 15:04 expected", date, clock)
+	}
+	return t, nil
+}
+
+// parseTime parses an optional RFC 3339 query parameter.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, RFC 3339 expected", s)
+	}
+	return t, nil
+}
+
+// problem is an RFC 7807 problem detail, served on every error.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
+
+// writeUpstreamError reports the failure of a call to upstream. While the
+// circuit breaker is open, the last cached response of the same request is
+// served instead when there is one, however old it is.
+func (ra *railAPI) writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).WithError(err).Warn("upstream call failed")
+	if r.Context().Err() != nil {
+		// The caller is gone, nobody reads the response.
+		return
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		if entry, ok := ra.cache.peek(cacheKey(r)); ok {
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			ra.writeEntry(w, r, entry, cacheStale)
 			return
 		}
-		json.NewEncoder(w).Encode(s)
+		if retryAt := ra.client.CircuitStatus().RetryAt; retryAt != nil {
+			seconds := int(math.Ceil(time.Until(*retryAt).Seconds()))
+			if seconds > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			}
+		}
+	}
+	writeProblem(w, gatewayStatus(err), err.Error())
+}
+
+// serveCached serves the JSON encoding of the value returned by load, going
+// through the response cache with the given time to live. The load is given
+// the request context, bounded by the timeout of the route. Conditional
+// requests matching the served entry are answered with 304. The cache status
+// is added to the span of the request.
+func (ra *railAPI) serveCached(w http.ResponseWriter, r *http.Request, ttl, timeout time.Duration, load func(context.Context) (interface{}, error)) {
+	ctx := r.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	entry, status, err := ra.cache.get(ctx, cacheKey(r), ttl, func(ctx context.Context) ([]byte, error) {
+		v, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	})
+	if err != nil {
+		ra.writeUpstreamError(w, r, err)
+		return
+	}
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttribute("cache.status", string(status))
+	span.SetAttribute("cache.hit", status != cacheMiss)
+	ra.writeEntry(w, r, entry, status)
+}
+
+func (ra *railAPI) writeEntry(w http.ResponseWriter, r *http.Request, entry cacheEntry, status cacheStatus) {
+	ra.client.instrument.CacheLookedUp(logging.Route(r), string(status))
+	ra.cache.writeCacheHeaders(w, entry, status)
+	if notModified(r, entry) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeBody(w, entry.body)
+}
+
+// cacheKey identifies the response of the request, the query parameters
+// being sorted.
+func cacheKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+func (ra *railAPI) circuit() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ra.client.CircuitStatus())
 	}
 }
 
+func writeBody(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (ra *railAPI) Shutdown() {
+	ra.disturbances.close()
+}
+
+func (ra *railAPI) Reconfigure(config APIConfig) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.config = config
+}
+
+func (ra *railAPI) currentConfig() APIConfig {
+	ra.mu.RLock()
+	defer ra.mu.RUnlock()
+	return ra.config
+}
+
+// handle adds a GET route, reported to the instrumentation of the client.
+func (ra *railAPI) handle(router *mux.Router, path string, handler http.HandlerFunc) {
+	instrument := ra.client.instrument
+	router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		instrument.RouteStarted(path)
+		rec := logging.NewStatusRecorder(w)
+		defer func() {
+			instrument.RouteServed(path, rec.Status, time.Since(start))
+		}()
+		handler(rec, r)
+	}).Methods("GET")
+}
+
 func (ra *railAPI) AddRoute(router *mux.Router) {
-	router.HandleFunc("/stations", ra.stations()).Methods("GET")
-}
\ No newline at end of file
+	ra.handle(router, "/stations", ra.stations())
+	ra.handle(router, "/stations/{id}/liveboard", ra.liveboard())
+	ra.handle(router, "/connections", ra.connections())
+	ra.handle(router, "/vehicles/{id}", ra.vehicle())
+	ra.handle(router, "/vehicles/{id}/composition", ra.composition())
+	ra.handle(router, "/disturbances", ra.disturbanceList())
+	ra.handle(router, "/disturbances/stream", ra.disturbanceStream())
+	ra.handle(router, "/status/circuit", ra.circuit())
+}
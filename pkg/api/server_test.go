@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/tracing"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve sends the request to a rail API whose upstream is the given handler.
+func serve(t *testing.T, upstream http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	NewRailAPI(APIConfig{}, newTestClient(t, upstream)).AddRoute(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestStations(t *testing.T) {
+	rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, stationsPayload)
+	}, "/stations")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var list StationList
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&list))
+	assert.Len(t, list.Stations, 1)
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		upstream int
+		want     int
+	}{
+		{http.StatusInternalServerError, http.StatusBadGateway},
+		{http.StatusBadGateway, http.StatusBadGateway},
+		{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		{http.StatusGatewayTimeout, http.StatusGatewayTimeout},
+		{http.StatusNotFound, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.upstream), func(t *testing.T) {
+			rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.upstream)
+			}, "/stations")
+
+			assert.Equal(t, tt.want, rec.Code)
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+			var p problem
+			require.Nil(t, json.NewDecoder(rec.Body).Decode(&p))
+			assert.Equal(t, tt.want, p.Status)
+			assert.Contains(t, p.Detail, fmt.Sprintf("returned %d", tt.upstream))
+		})
+	}
+}
+
+func TestLiveboard_BadRequest(t *testing.T) {
+	rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("upstream must not be called")
+	}, "/stations/BE.NMBS.008892007/liveboard?direction=sideways")
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+}
+
+func TestStations_RouteTimeout(t *testing.T) {
+	router := mux.NewRouter()
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	NewRailAPI(APIConfig{StationsTimeoutMillis: 20 * time.Millisecond}, client).AddRoute(router)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
+
+func TestReconfigure(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, stationsPayload)
+	})
+	router := mux.NewRouter()
+	ra := NewRailAPI(APIConfig{}, client)
+	ra.AddRoute(router)
+	get := func() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	get()
+	get()
+	require.Equal(t, 2, calls, "cache disabled")
+	ra.Reconfigure(APIConfig{StationsCacheTTLSeconds: time.Minute})
+	get()
+	get()
+
+	assert.Equal(t, 3, calls)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	previous := tracing.SetTracer(tracing.NewTracer(tracing.Config{}, exporter))
+	t.Cleanup(func() { tracing.SetTracer(previous) })
+	calls := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, stationsPayload)
+	})
+	router := mux.NewRouter()
+	router.Use(tracing.Middleware)
+	NewRailAPI(APIConfig{StationsCacheTTLSeconds: time.Minute}, client).AddRoute(router)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stations", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 4)
+	failed, succeeded, miss, hit := spans[0], spans[1], spans[2], spans[3]
+	assert.Equal(t, "GET /stations/", failed.Name)
+	assert.Equal(t, tracing.SpanKindClient, failed.Kind)
+	assert.Equal(t, 1, failed.Attributes["retry.attempt"])
+	assert.Equal(t, http.StatusServiceUnavailable, failed.Attributes["http.status_code"])
+	assert.Equal(t, "127.0.0.1", failed.Attributes["net.peer.name"])
+	assert.Equal(t, tracing.StatusError, failed.Status)
+	assert.Equal(t, 2, succeeded.Attributes["retry.attempt"])
+	assert.Equal(t, http.StatusOK, succeeded.Attributes["http.status_code"])
+	assert.Equal(t, miss.SpanID, failed.ParentSpanID)
+	assert.Equal(t, miss.SpanID, succeeded.ParentSpanID)
+	assert.Equal(t, "GET /stations", miss.Name)
+	assert.Equal(t, false, miss.Attributes["cache.hit"])
+	assert.Equal(t, "MISS", miss.Attributes["cache.status"])
+	assert.Equal(t, true, hit.Attributes["cache.hit"])
+	assert.Equal(t, "HIT", hit.Attributes["cache.status"])
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/logging"
+)
+
+// disturbanceFeed polls the disturbances on behalf of all the stream
+// subscribers, so that upstream is called once per interval whatever the
+// number of clients, and not at all when nobody listens. A subscriber too slow
+// to take an update is disconnected, to get a fresh snapshot when it comes
+// back rather than missing the update.
+type disturbanceFeed struct {
+	client   RailClient
+	interval time.Duration
+	// heartbeat is how often the streams send a comment, so that idle
+	// connections are not closed by the proxies on the way.
+	heartbeat time.Duration
+
+	mu          sync.Mutex
+	subscribers map[chan []Disturbance]struct{}
+	known       map[string]Disturbance
+	stop        chan struct{}
+	closed      bool
+}
+
+// defaultPollInterval replaces a poll interval that is not positive, which
+// the ticker of the feed would not accept. It matches the value tag default.
+const defaultPollInterval = time.Minute
+
+const defaultHeartbeat = 15 * time.Second
+
+func newDisturbanceFeed(client RailClient, interval time.Duration) *disturbanceFeed {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &disturbanceFeed{
+		client:      client,
+		interval:    interval,
+		heartbeat:   defaultHeartbeat,
+		subscribers: make(map[chan []Disturbance]struct{}),
+		known:       make(map[string]Disturbance),
+	}
+}
+
+// subscribe registers a subscriber and returns its channel along with the
+// disturbances already known. The polling starts with the first subscriber.
+// The channel is closed when the feed is.
+func (f *disturbanceFeed) subscribe() (chan []Disturbance, []Disturbance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan []Disturbance, 8)
+	if f.closed {
+		close(ch)
+		return ch, nil
+	}
+	f.subscribers[ch] = struct{}{}
+	if f.stop == nil {
+		f.stop = make(chan struct{})
+		go f.run(f.stop)
+	}
+	current := make([]Disturbance, 0, len(f.known))
+	for _, d := range f.known {
+		current = append(current, d)
+	}
+	return ch, current
+}
+
+// unsubscribe removes the subscriber. The polling stops with the last one.
+func (f *disturbanceFeed) unsubscribe(ch chan []Disturbance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, ch)
+	if len(f.subscribers) == 0 && f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+// close stops the polling and closes the channels of all the subscribers,
+// present and future.
+func (f *disturbanceFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for ch := range f.subscribers {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+func (f *disturbanceFeed) run(stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		f.poll(ctx)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches the disturbances, ctx being cancelled when the polling stops.
+func (f *disturbanceFeed) poll(ctx context.Context) {
+	list, err := f.client.GetDisturbances(ctx)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("disturbances not polled")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changed := diffDisturbances(f.known, list.Disturbances)
+	if len(changed) == 0 {
+		return
+	}
+	for ch := range f.subscribers {
+		select {
+		case ch <- changed:
+		default:
+			delete(f.subscribers, ch)
+			close(ch)
+			logging.FromContext(ctx).Warn("disturbance subscriber too slow, disconnected")
+		}
+	}
+}
+
+// diffDisturbances returns the disturbances that are new or differ from the
+// known ones, and records them as known. Disturbances no longer listed are
+// forgotten so that they are sent again if they come back.
+func diffDisturbances(known map[string]Disturbance, disturbances []Disturbance) []Disturbance {
+	var changed []Disturbance
+	listed := make(map[string]struct{}, len(disturbances))
+	for _, d := range disturbances {
+		listed[d.ID] = struct{}{}
+		if k, ok := known[d.ID]; ok && k == d {
+			continue
+		}
+		known[d.ID] = d
+		changed = append(changed, d)
+	}
+	for id := range known {
+		if _, ok := listed[id]; !ok {
+			delete(known, id)
+		}
+	}
+	return changed
+}
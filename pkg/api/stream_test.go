@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffDisturbances(t *testing.T) {
+	known := make(map[string]Disturbance)
+	strike := Disturbance{ID: "strike", Title: "Strike"}
+	works := Disturbance{ID: "works", Title: "Works"}
+
+	assert.Equal(t, []Disturbance{strike, works}, diffDisturbances(known, []Disturbance{strike, works}))
+	assert.Empty(t, diffDisturbances(known, []Disturbance{strike, works}))
+
+	worksUpdated := Disturbance{ID: "works", Title: "Works extended"}
+	assert.Equal(t, []Disturbance{worksUpdated}, diffDisturbances(known, []Disturbance{strike, worksUpdated}))
+
+	assert.Empty(t, diffDisturbances(known, []Disturbance{strike}))
+	assert.NotContains(t, known, "works")
+	assert.Equal(t, []Disturbance{worksUpdated}, diffDisturbances(known, []Disturbance{strike, worksUpdated}))
+}
+
+func TestDisturbanceStream_Shutdown(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, disturbancesPayload)
+	})
+	ra := NewRailAPI(APIConfig{DisturbancesPollIntervalSeconds: time.Hour}, client)
+	router := mux.NewRouter()
+	ra.AddRoute(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/disturbances/stream")
+	require.Nil(t, err)
+	defer res.Body.Close()
+	ra.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			if _, err := res.Body.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream not ended on shutdown")
+	}
+
+	ch, current := ra.(*railAPI).disturbances.subscribe()
+	_, ok := <-ch
+	assert.False(t, ok, "subscribing after shutdown ends at once")
+	assert.Empty(t, current)
+}
+
+func TestDisturbanceStream_DefaultInterval(t *testing.T) {
+	polled := make(chan struct{}, 1)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, disturbancesPayload)
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+	})
+	ra := NewRailAPI(APIConfig{}, client)
+	defer ra.Shutdown()
+	feed := ra.(*railAPI).disturbances
+	assert.Equal(t, defaultPollInterval, feed.interval)
+
+	ch, _ := feed.subscribe()
+	defer feed.unsubscribe(ch)
+	select {
+	case <-polled:
+	case <-time.After(time.Second):
+		t.Fatal("disturbances not polled")
+	}
+}
+
+func TestDisturbanceFeed_SlowSubscriber(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, disturbancesPayload)
+	})
+	feed := newDisturbanceFeed(client, time.Hour)
+	slow := make(chan []Disturbance, 1)
+	slow <- nil
+	feed.subscribers[slow] = struct{}{}
+
+	feed.poll(context.Background())
+
+	<-slow
+	_, ok := <-slow
+	assert.False(t, ok, "slow subscriber disconnected rather than missing the update")
+	assert.Empty(t, feed.subscribers)
+}
+
+func TestDisturbanceStream_Heartbeat(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, disturbancesPayload)
+	})
+	ra := NewRailAPI(APIConfig{DisturbancesPollIntervalSeconds: time.Hour}, client)
+	defer ra.Shutdown()
+	ra.(*railAPI).disturbances.heartbeat = 10 * time.Millisecond
+	router := mux.NewRouter()
+	ra.AddRoute(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/disturbances/stream")
+	require.Nil(t, err)
+	defer res.Body.Close()
+
+	found := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if scanner.Text() == ":" {
+				close(found)
+				return
+			}
+		}
+	}()
+	select {
+	case <-found:
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat sent")
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+)
+
+// maxValidators bounds the number of upstream URLs whose validators are kept.
+const maxValidators = 512
+
+// validated is an upstream response body along with the validators upstream
+// sent for it.
+type validated struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// validatorStore remembers the validators of the upstream responses, so that
+// later requests of the same URL are conditional and a 304 spares the
+// download of a body that did not change.
+type validatorStore struct {
+	mu        sync.Mutex
+	responses map[string]validated
+}
+
+func newValidatorStore() *validatorStore {
+	return &validatorStore{responses: make(map[string]validated)}
+}
+
+// prepare makes the request conditional when validators are known for its URL
+// and returns the body they validate.
+func (s *validatorStore) prepare(req *http.Request) (validated, bool) {
+	s.mu.Lock()
+	v, ok := s.responses[req.URL.String()]
+	s.mu.Unlock()
+	if !ok {
+		return v, false
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+	return v, true
+}
+
+// remember stores the validators of the response, if any, along with its
+// body. An arbitrary URL is forgotten when the store is full.
+func (s *validatorStore) remember(req *http.Request, res *http.Response, body []byte) {
+	v := validated{
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+		body:         body,
+	}
+	key := req.URL.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v.etag == "" && v.lastModified == "" {
+		delete(s.responses, key)
+		return
+	}
+	if _, ok := s.responses[key]; !ok && len(s.responses) >= maxValidators {
+		for k := range s.responses {
+			delete(s.responses, k)
+			break
+		}
+	}
+	s.responses[key] = v
+}
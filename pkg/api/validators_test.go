@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetch_ConditionalRequests(t *testing.T) {
+	var downloads, notModified int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, stationsPayload)
+	})
+
+	for i := 0; i < 3; i++ {
+		stations, err := client.GetStations(context.Background())
+		require.Nil(t, err)
+		require.Len(t, stations.Stations, 1)
+		assert.Equal(t, "Ghent-Sint-Pieters", stations.Stations[0].Name)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&notModified))
+}
+
+func TestFetch_LastModified(t *testing.T) {
+	const lastModified = "Sun, 16 Feb 2020 12:00:00 GMT"
+	var conditional int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, disturbancesPayload)
+	})
+
+	_, err := client.GetDisturbances(context.Background())
+	require.Nil(t, err)
+	d, err := client.GetDisturbances(context.Background())
+	require.Nil(t, err)
+
+	assert.Len(t, d.Disturbances, 2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conditional))
+}
+
+func TestFetch_WithoutValidators(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("If-None-Match"))
+		assert.Empty(t, r.Header.Get("If-Modified-Since"))
+		fmt.Fprint(w, stationsPayload)
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetStations(context.Background())
+		require.Nil(t, err)
+	}
+}
@@ -0,0 +1,266 @@
+package autoconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigFileEnv is the environment variable listing, comma separated, the configuration files to load.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// ConfigPathEnv is the environment variable listing, comma separated, the directories searched for the
+// application configuration file.
+const ConfigPathEnv = "CONFIG_PATH"
+
+// AppProfilesEnv is the environment variable listing, comma separated, the active profiles. The files of a
+// profile overlay the configuration file they are named after, application-dev.yaml overlaying application.yaml.
+const AppProfilesEnv = "APP_PROFILES"
+
+// ConfigName is the name, without extension, of the configuration file searched in the CONFIG_PATH directories.
+const ConfigName = "application"
+
+// ConfigExtensions are the supported configuration file extensions, in their search order.
+var ConfigExtensions = []string{"yaml", "yml", "json", "toml", "env"}
+
+var files = &fileSource{}
+
+// fileSource holds the properties loaded from the configuration files, by environment variable name so that
+// a property is found whether the file nests it (yaml, json, toml) or names it as a variable (dotenv).
+type fileSource struct {
+	sync.RWMutex
+	loaded bool
+	err    error
+	values map[string]fileValue
+	paths  []string
+}
+
+// fileValue is the value of a property along with the file defining it.
+type fileValue struct {
+	value string
+	path  string
+}
+
+// lookup returns the value of the given property in the configuration files, loading them on first use, along
+// with its source.
+func (f *fileSource) lookup(property string) (string, string, bool, error) {
+	f.RLock()
+	loaded := f.loaded
+	f.RUnlock()
+	if !loaded {
+		f.load()
+	}
+	f.RLock()
+	defer f.RUnlock()
+	if f.err != nil {
+		return "", "", false, f.err
+	}
+	v, ok := f.values[toEnvName(property)]
+	return v.value, SourceFile + " " + v.path, ok, nil
+}
+
+func (f *fileSource) load() {
+	f.Lock()
+	defer f.Unlock()
+	if f.loaded {
+		return
+	}
+	f.loaded = true
+	f.paths, f.err = configFiles()
+	if f.err != nil {
+		return
+	}
+	f.values, f.err = readFiles(f.paths)
+}
+
+// reload reads the configuration files again, keeping the previous properties when it fails.
+func (f *fileSource) reload() error {
+	paths, err := configFiles()
+	if err != nil {
+		return err
+	}
+	values, err := readFiles(paths)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.loaded = true
+	f.err = nil
+	f.values = values
+	f.paths = paths
+	return nil
+}
+
+// replace replaces the properties with the ones of the given source.
+func (f *fileSource) replace(source *fileSource) {
+	source.RLock()
+	defer source.RUnlock()
+	f.Lock()
+	defer f.Unlock()
+	f.loaded = source.loaded
+	f.err = source.err
+	f.values = source.values
+	f.paths = source.paths
+}
+
+func (f *fileSource) reset() {
+	f.Lock()
+	defer f.Unlock()
+	f.loaded = false
+	f.err = nil
+	f.values = nil
+	f.paths = nil
+}
+
+// ConfigFiles returns the configuration files the properties are loaded from, in their loading order.
+func ConfigFiles() ([]string, error) {
+	files.load()
+	files.RLock()
+	defer files.RUnlock()
+	return append([]string(nil), files.paths...), files.err
+}
+
+// configFiles returns the application file found in the first CONFIG_PATH directory holding one, followed by
+// the CONFIG_FILE files. The files listed in CONFIG_FILE must exist. Each file is followed by the overlays of
+// the APP_PROFILES profiles found next to it, in the order of the profiles.
+func configFiles() ([]string, error) {
+	profiles, err := appProfiles()
+	if err != nil {
+		return nil, err
+	}
+	var bases []string
+	for _, dir := range splitList(os.Getenv(ConfigPathEnv)) {
+		path, err := findConfigFile(dir, ConfigName)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			bases = append(bases, path)
+			break
+		}
+	}
+	for _, path := range splitList(os.Getenv(ConfigFileEnv)) {
+		_, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read configuration file: %v", err)
+		}
+		bases = append(bases, path)
+	}
+	var paths []string
+	for _, base := range bases {
+		paths = append(paths, base)
+		dir, name := filepath.Split(base)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		for _, profile := range profiles {
+			path, err := findConfigFile(dir, name+"-"+profile)
+			if err != nil {
+				return nil, err
+			}
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// appProfiles returns the APP_PROFILES profiles, which must be usable in a file name.
+func appProfiles() ([]string, error) {
+	profiles := splitList(os.Getenv(AppProfilesEnv))
+	for _, profile := range profiles {
+		if !profileFormat.MatchString(profile) {
+			return nil, fmt.Errorf("unsupported profile format, only letters, digits, dots, hyphens and underscores are supported: %s", profile)
+		}
+	}
+	return profiles, nil
+}
+
+var profileFormat = regexp.MustCompile(`^[a-zA-Z\d][a-zA-Z\d\.\-_]*$`)
+
+func findConfigFile(dir, name string) (string, error) {
+	for _, ext := range ConfigExtensions {
+		path := filepath.Join(dir, name+"."+ext)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("unable to read configuration file: %v", err)
+		}
+	}
+	return "", nil
+}
+
+// readFiles reads the given files in order, the properties of a file overriding the ones of the previous files.
+func readFiles(paths []string) (map[string]fileValue, error) {
+	values := map[string]fileValue{}
+	for _, path := range paths {
+		err := readFile(path, values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func readFile(path string, values map[string]fileValue) error {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if !isConfigExtension(ext) {
+		return fmt.Errorf("unsupported configuration file format: %s", path)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if ext == "env" {
+		v.SetConfigType("dotenv")
+	}
+	err := v.ReadInConfig()
+	if err != nil {
+		return fmt.Errorf("unable to read configuration file %s: %v", path, err)
+	}
+	for _, key := range v.AllKeys() {
+		values[toEnvName(key)] = fileValue{value: toString(v.Get(key)), path: path}
+	}
+	return nil
+}
+
+func isConfigExtension(ext string) bool {
+	for _, e := range ConfigExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// toString formats a file value the way the environment variables are given, lists being blank separated.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = fmt.Sprint(e)
+		}
+		return strings.Join(s, " ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
@@ -0,0 +1,204 @@
+package autoconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/autoconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fileConf struct {
+	BaseURL       string        `value:"rail.client.base-url|http://localhost"`
+	TimeoutMillis time.Duration `value:"rail.client.timeout|1000"`
+	Statuses      []string      `value:"rail.client.retry.statuses|500"`
+	Enabled       bool          `value:"rail.client.enabled|false"`
+	Language      string        `value:"rail.client.language|en"`
+}
+
+// clearEnvironment clears the environment now and once the test is done, so that the configuration
+// files of the test are not seen by the next ones.
+func clearEnvironment(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	t.Cleanup(autoconfig.ClearEnvironment)
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestAutoConfigure_Files(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"application.yaml", `
+rail:
+  client:
+    base-url: https://api.irail.be
+    timeout: 2500
+    retry:
+      statuses: [502, 503]
+    enabled: true
+`},
+		{"application.json", `{"rail": {"client": {"base-url": "https://api.irail.be", "timeout": 2500,
+			"retry": {"statuses": [502, 503]}, "enabled": true}}}`},
+		{"application.toml", `
+[rail.client]
+base-url = "https://api.irail.be"
+timeout = 2500
+enabled = true
+[rail.client.retry]
+statuses = [502, 503]
+`},
+		{"application.env", `
+RAIL_CLIENT_BASEURL=https://api.irail.be
+RAIL_CLIENT_TIMEOUT=2500
+RAIL_CLIENT_RETRY_STATUSES="502 503"
+RAIL_CLIENT_ENABLED=true
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			clearEnvironment(t)
+			os.Setenv(autoconfig.ConfigFileEnv, writeFile(t, dir, tt.name, tt.content))
+			conf := &fileConf{}
+
+			err := autoconfig.AutoConfigure(conf)
+
+			require.NoError(t, err)
+			assert.Equal(t, "https://api.irail.be", conf.BaseURL)
+			assert.Equal(t, 2500*time.Millisecond, conf.TimeoutMillis)
+			assert.Equal(t, []string{"502", "503"}, conf.Statuses)
+			assert.True(t, conf.Enabled)
+			assert.Equal(t, "en", conf.Language)
+		})
+	}
+}
+
+func TestAutoConfigure_Files_Priorities(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	os.Setenv(autoconfig.ConfigFileEnv, writeFile(t, dir, "application.yaml", `
+rail.client.base-url: https://file.example.org
+rail.client.timeout: 2500
+`))
+	os.Setenv("RAIL_CLIENT_BASEURL", "https://env.example.org")
+	conf := &fileConf{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "https://env.example.org", conf.BaseURL, "env beats file")
+	assert.Equal(t, 2500*time.Millisecond, conf.TimeoutMillis, "file beats default")
+	assert.Equal(t, "en", conf.Language, "default when nothing else")
+}
+
+func TestAutoConfigure_Files_Order(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	writeFile(t, dir, "application.json", `{"rail.client.language": "nl", "rail.client.timeout": 2500}`)
+	override := writeFile(t, dir, "override.env", "RAIL_CLIENT_TIMEOUT=3000\n")
+	os.Setenv(autoconfig.ConfigPathEnv, filepath.Join(dir, "missing")+","+dir)
+	os.Setenv(autoconfig.ConfigFileEnv, override)
+	conf := &fileConf{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "nl", conf.Language)
+	assert.Equal(t, 3000*time.Millisecond, conf.TimeoutMillis)
+	files, err := autoconfig.ConfigFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "application.json"), override}, files)
+}
+
+func TestAutoConfigure_Files_SearchOrder(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	writeFile(t, dir, "application.yaml", "rail.client.language: fr\n")
+	writeFile(t, dir, "application.json", `{"rail.client.language": "nl"}`)
+	os.Setenv(autoconfig.ConfigPathEnv, dir)
+	conf := &fileConf{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "fr", conf.Language)
+}
+
+func TestAutoConfigure_Err_Files(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"missing", filepath.Join(dir, "missing.yaml"), "unable to read configuration file: stat " + filepath.Join(dir, "missing.yaml") + ": no such file or directory"},
+		{"unsupported", writeFile(t, dir, "application.xml", "<rail/>"), "unsupported configuration file format: " + filepath.Join(dir, "application.xml")},
+		{"invalid", writeFile(t, dir, "invalid.json", "{"), "unable to read configuration file " + filepath.Join(dir, "invalid.json") + ": While parsing config: unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvironment(t)
+			os.Setenv(autoconfig.ConfigFileEnv, tt.file)
+
+			err := autoconfig.AutoConfigure(&fileConf{})
+
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestAutoConfigure_Profiles(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	writeFile(t, dir, "application.yaml", `
+rail.client:
+  base-url: https://api.irail.be
+  timeout: 2500
+  language: nl
+`)
+	writeFile(t, dir, "application-dev.yaml", "rail.client.timeout: 9000\nrail.client.language: fr\n")
+	writeFile(t, dir, "application-nm-dev.json", `{"rail": {"client": {"language": "de"}}}`)
+	writeFile(t, dir, "application-prod.yaml", "rail.client.base-url: https://prod.example.org\n")
+	override := writeFile(t, dir, "override.yaml", "rail.client.enabled: false\n")
+	writeFile(t, dir, "override-nm-dev.env", "RAIL_CLIENT_ENABLED=true\n")
+	os.Setenv(autoconfig.ConfigPathEnv, dir)
+	os.Setenv(autoconfig.ConfigFileEnv, override)
+	os.Setenv(autoconfig.AppProfilesEnv, "dev, nm-dev,missing")
+	os.Setenv("RAIL_CLIENT_TIMEOUT", "1500")
+	conf := &fileConf{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.irail.be", conf.BaseURL, "base file when no overlay")
+	assert.Equal(t, "de", conf.Language, "last profile wins")
+	assert.Equal(t, 1500*time.Millisecond, conf.TimeoutMillis, "env beats profiles")
+	assert.True(t, conf.Enabled, "profile of an explicit file")
+	files, err := autoconfig.ConfigFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "application.yaml"),
+		filepath.Join(dir, "application-dev.yaml"),
+		filepath.Join(dir, "application-nm-dev.json"),
+		override,
+		filepath.Join(dir, "override-nm-dev.env"),
+	}, files)
+}
+
+func TestAutoConfigure_Err_Profiles(t *testing.T) {
+	clearEnvironment(t)
+	os.Setenv(autoconfig.AppProfilesEnv, "dev,../secrets")
+
+	err := autoconfig.AutoConfigure(&fileConf{})
+
+	assert.EqualError(t, err, "unsupported profile format, only letters, digits, dots, hyphens and underscores are supported: ../secrets")
+}
@@ -21,23 +21,53 @@ const sepReplaced = "{{{{{}}}}}"
 
 var vipUpdate = &updater{}
 
+// updater stores the configured values into viper. As they are stored parsed, durations in nanoseconds for
+// instance, a value read from viper while it still holds the value stored by the updater is the raw value viper
+// held beforehand, so that configuring twice gives the same result. A value set in viper afterwards is read as is.
 type updater struct {
 	sync.RWMutex
+	written map[string]writtenValue
+}
+
+// writtenValue is a value stored by the updater along with the raw value viper held before.
+type writtenValue struct {
+	stored interface{}
+	raw    string
 }
 
 func (u *updater) set(key string, value interface{}) {
 	u.Lock()
 	defer u.Unlock()
+	if u.written == nil {
+		u.written = map[string]writtenValue{}
+	}
+	u.written[key] = writtenValue{stored: value, raw: u.raw(key)}
 	viper.Set(key, value)
 }
 
 func (u *updater) getString(key string) string {
 	u.RLock()
 	defer u.RUnlock()
+	return u.raw(key)
+}
+
+// raw returns the value of viper, or the raw value it held before when it still holds the value stored by the
+// updater.
+func (u *updater) raw(key string) string {
+	if w, ok := u.written[key]; ok && reflect.DeepEqual(viper.Get(key), w.stored) {
+		return w.raw
+	}
 	return viper.GetString(key)
 }
 
-// OrPanic loads the given interface from the environment variables,
+func (u *updater) reset() {
+	u.Lock()
+	defer u.Unlock()
+	u.written = nil
+	viper.Reset()
+}
+
+// OrPanic loads the given interface from the environment variables and the configuration files,
 // stores them into viper but panics in case of failure: typically when there is
 // a parsing error on the variable's value or default value.
 func OrPanic(i interface{}) {
@@ -55,7 +85,7 @@ func ValueOrPanic(v interface{}, valueTag string) {
 		panic(fmt.Errorf("duration not supported by ValueOrPanic"))
 	}
 	value := reflect.ValueOf(v).Elem()
-	err := applyValue(value, t, "", valueTag)
+	_, err := newConfigurer().applyValue(value, t, "", valueTag)
 	if err != nil {
 		panic(fmt.Errorf("unable to auto configure value: %v", err))
 	}
@@ -68,77 +98,208 @@ func DurationOrPanic(valueTag, unit string) time.Duration {
 	var d time.Duration
 	value := reflect.ValueOf(&d).Elem()
 	t := reflect.TypeOf(&d).Elem()
-	err := applyValue(value, t, unit, valueTag)
+	_, err := newConfigurer().applyValue(value, t, unit, valueTag)
 	if err != nil {
 		panic(fmt.Errorf("unable to auto configure duration: %v", err))
 	}
 	return d
 }
 
-// AutoConfigure loads the given interface from the environment variables,
+// AutoConfigure loads the given interface from the environment variables and the configuration files,
 // stores them into viper and returns an error in case of failure: typically when there is
 // a parsing error on the variable's value or default value.
+// Nested and embedded structs, and pointers to structs, are configured recursively, nil
+// pointers being allocated when the struct they point to holds value tags. The properties
+// of a nested struct can be mounted under a namespace with the prefix tag.
+// The values are checked against the rules of their validate tag, every invalid property
+// being listed by the returned Errors.
 // Prefer OrPanic as most of the time it is better to do a panic when the application
 // fails to get its configuration.
 func AutoConfigure(i interface{}) error {
-	values := reflect.ValueOf(i).Elem()
-	types := reflect.TypeOf(i).Elem()
+	return newConfigurer().configure(reflect.ValueOf(i).Elem())
+}
+
+// configurer configures a struct and its nested structs. The types being configured are
+// tracked so that a recursive type does not allocate pointers endlessly.
+type configurer struct {
+	configuring map[reflect.Type]bool
+	// files holds the properties of the configuration files.
+	files *fileSource
+	// values records the applied values by property when not nil.
+	values map[string]interface{}
+	// pending holds the values to store into viper by property when not nil, instead of storing them at once.
+	pending map[string]interface{}
+	// invalid holds the properties whose value cannot be parsed or is not valid.
+	invalid Errors
+}
+
+func newConfigurer() *configurer {
+	return &configurer{configuring: map[reflect.Type]bool{}, files: files}
+}
+
+// configure configures the given struct, returning at the first error of the tags or of the
+// configuration files, but only once every property is parsed and validated otherwise.
+func (c *configurer) configure(value reflect.Value) error {
+	err := c.configureStruct(value, "")
+	if err != nil {
+		return err
+	}
+	if len(c.invalid) > 0 {
+		return c.invalid
+	}
+	return nil
+}
+
+func (c *configurer) configureStruct(values reflect.Value, prefix string) error {
+	types := values.Type()
+	c.configuring[types] = true
+	defer delete(c.configuring, types)
 	for i := 0; i < values.NumField(); i++ {
 		fValue := values.Field(i)
 		fType := types.Field(i)
+		fPrefix := joinPrefix(prefix, fType.Tag.Get("prefix"))
 		valueTag := fType.Tag.Get("value")
-		err := applyValue(fValue, fType.Type, fType.Name, valueTag)
+		if valueTag == "" {
+			switch {
+			case isStruct(fType.Type) && (fValue.CanSet() || fType.Anonymous):
+				// The exported fields of an embedded struct are settable even when its type is not.
+				err := c.configureStruct(fValue, fPrefix)
+				if err != nil {
+					return err
+				}
+				continue
+			case fValue.CanSet() && fType.Type.Kind() == reflect.Ptr && isStruct(fType.Type.Elem()):
+				if c.configuring[fType.Type.Elem()] {
+					continue
+				}
+				if fValue.IsNil() {
+					if !hasValueTags(fType.Type.Elem(), map[reflect.Type]bool{}) {
+						continue
+					}
+					fValue.Set(reflect.New(fType.Type.Elem()))
+				}
+				err := c.configureStruct(fValue.Elem(), fPrefix)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if valueTag != "" && fPrefix != "" {
+			valueTag = fPrefix + "." + valueTag
+		}
+		r, err := c.applyValue(fValue, fType.Type, fType.Name, valueTag)
+		var invalid *PropertyError
+		if errors.As(err, &invalid) {
+			c.invalid = append(c.invalid, invalid)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if valueTag == "" || !fValue.CanSet() {
+			continue
+		}
+		err = validateValue(fValue, fType, r)
+		if errors.As(err, &invalid) {
+			c.invalid = append(c.invalid, invalid)
+			continue
+		}
 		if err != nil {
 			return err
 		}
+		if c.values != nil {
+			c.values[r.property] = fValue.Interface()
+		}
 	}
 	return nil
 }
 
-func applyValue(fValue reflect.Value, fType reflect.Type, fTypeName string, valueTag string) error {
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct
+}
+
+// hasValueTags tells whether the given struct, or one of its nested structs, holds a value tag.
+func hasValueTags(t reflect.Type, visited map[reflect.Type]bool) bool {
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("value") != "" {
+			return true
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if isStruct(ft) && !visited[ft] && hasValueTags(ft, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPrefix(prefix, name string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + "." + name
+}
+
+func (c *configurer) applyValue(fValue reflect.Value, fType reflect.Type, fTypeName string, valueTag string) (resolvedValue, error) {
 	if fValue.CanSet() && valueTag != "" {
-		property, value, err := getValueFromTag(valueTag)
+		r, err := c.getValueFromTag(valueTag)
 		if err != nil {
-			return err
+			return r, err
 		}
+		property, value := r.property, r.value
 		switch fType.String() {
 		case "string":
 			fValue.SetString(value)
-			vipUpdate.set(property, value)
+			c.store(property, value)
 		case "[]string":
 			values := strings.Split(value, " ")
 			if value == "" {
 				values = []string{}
 			}
 			fValue.Set(reflect.ValueOf(values))
-			vipUpdate.set(property, values)
+			c.store(property, values)
 		case "bool":
 			boolValue, err := strconv.ParseBool(value)
 			if err != nil {
-				return fmt.Errorf("error while parsing boolean value %v for tag %v: %v", value, valueTag, err)
+				return r, r.invalid(fmt.Errorf("error while parsing boolean value %v for tag %v: %v", value, valueTag, err))
 			}
 			fValue.SetBool(boolValue)
-			vipUpdate.set(property, boolValue)
+			c.store(property, boolValue)
 		case "int", "int8", "int16", "int32", "int64":
 			intValue, err := strconv.ParseInt(value, 10, 64)
 			if err != nil {
-				return fmt.Errorf("error while parsing int value %v for tag %v: %v", value, valueTag, err)
+				return r, r.invalid(fmt.Errorf("error while parsing int value %v for tag %v: %v", value, valueTag, err))
 			}
 			fValue.SetInt(intValue)
-			vipUpdate.set(property, intValue)
+			c.store(property, intValue)
 		case "time.Duration":
 			unit := getUnitFromFieldName(fTypeName)
 			durationValue, err := time.ParseDuration(value + unit)
 			if err != nil {
-				return fmt.Errorf("error while parsing durationValue value %v for tag %v: %v", value, valueTag, err)
+				return r, r.invalid(fmt.Errorf("error while parsing durationValue value %v for tag %v: %v", value, valueTag, err))
 			}
 			fValue.SetInt(durationValue.Nanoseconds())
-			vipUpdate.set(property, durationValue.Nanoseconds())
+			c.store(property, durationValue.Nanoseconds())
 		default:
-			return fmt.Errorf("unsupported type for autoconfiguration: %s", fType.Name())
+			return r, fmt.Errorf("unsupported type for autoconfiguration: %s", fType.Name())
 		}
+		return r, nil
 	}
-	return nil
+	return resolvedValue{}, nil
+}
+
+// store stores the value into viper, or keeps it for later when the values are pending.
+func (c *configurer) store(property string, value interface{}) {
+	if c.pending != nil {
+		c.pending[property] = value
+		return
+	}
+	vipUpdate.set(property, value)
 }
 
 func getUnitFromFieldName(fieldName string) string {
@@ -164,30 +325,60 @@ func getUnitFromFieldName(fieldName string) string {
 	return "ms" //Milliseconds is default
 }
 
-func getValueFromTag(tag string) (string, string, error) {
+// Sources of the property values, a file source being followed by the path of the file.
+const (
+	SourceEnv     = "environment"
+	SourceFile    = "file"
+	SourceViper   = "viper"
+	SourceDefault = "default"
+)
+
+// resolvedValue is the value of a property along with the source it comes from.
+type resolvedValue struct {
+	property string
+	env      string
+	value    string
+	source   string
+}
+
+func (r resolvedValue) invalid(err error) *PropertyError {
+	return &PropertyError{Property: r.property, Env: r.env, Source: r.source, Value: r.value, Err: err}
+}
+
+func (c *configurer) getValueFromTag(tag string) (resolvedValue, error) {
 	property, env, def, err := parseTag(tag)
 	if err != nil {
-		return "", "", err
+		return resolvedValue{}, err
 	}
 
 	err = validatePropertyFormat(property)
 	if err != nil {
-		return "", "", err
+		return resolvedValue{}, err
 	}
 
 	//Highest Property source
+	source := SourceEnv
 	value, isSet := os.LookupEnv(env)
 
 	if !isSet {
+		value, source, isSet, err = c.files.lookup(property)
+		if err != nil {
+			return resolvedValue{}, err
+		}
+	}
+
+	if !isSet {
+		source = SourceViper
 		value = vipUpdate.getString(property)
 	}
 
 	//Default Property
 	if value == "" {
+		source = SourceDefault
 		value = def
 	}
 
-	return property, value, nil
+	return resolvedValue{property: property, env: env, value: value, source: source}, nil
 }
 
 func validatePropertyFormat(property string) error {
@@ -228,9 +419,16 @@ func toEnvName(propertyName string) string {
 	return withoutDash
 }
 
-// ClearEnvironment deletes all the environment variables and resets viper.
+// ClearEnvironment deletes all the environment variables, forgets the configuration files and resets viper.
 // It should be used for test purpose only.
 func ClearEnvironment() {
 	os.Clearenv()
-	viper.Reset()
+	Reset()
+}
+
+// Reset forgets the configuration files and resets viper, leaving the environment variables untouched. The
+// files are loaded again on next use. It should be used for test purpose only.
+func Reset() {
+	files.reset()
+	vipUpdate.reset()
 }
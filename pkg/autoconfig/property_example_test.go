@@ -1,11 +1,14 @@
 package autoconfig_test
 
 import (
-	"eurocontrol.io/digital-platform-product-deployment/pkg/autoconfig"
+	"eurocontrol.io/demo/egress/pkg/autoconfig"
 	"fmt"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExampleValueOrPanic(t *testing.T) {
@@ -45,13 +48,29 @@ func TestExampleAutoConfigure_embeddedStruct(t *testing.T) {
 		Another string `value:"my.property|works"`
 	}
 	autoconfig.OrPanic(&c)
-	autoconfig.OrPanic(&c.Em)
 	fmt.Println(c.Embedded, c.Another)
 
 	// Output:
 	// it works
 }
 
+func TestExampleAutoConfigure_prefix(t *testing.T) {
+	type Endpoint struct {
+		URL string `value:"url|http://localhost"`
+	}
+	var c struct {
+		Primary Endpoint  `prefix:"primary"`
+		Backup  *Endpoint `prefix:"backup"`
+	}
+	os.Setenv("BACKUP_URL", "http://backup")
+	defer os.Unsetenv("BACKUP_URL")
+	autoconfig.OrPanic(&c)
+
+	assert.Equal(t, "http://localhost", c.Primary.URL)
+	require.NotNil(t, c.Backup)
+	assert.Equal(t, "http://backup", c.Backup.URL)
+}
+
 func TestExampleAutoConfigure_duration(t *testing.T) {
 	var c struct {
 		Ten        time.Duration `value:"one|10"`
@@ -1,7 +1,7 @@
 package autoconfig_test
 
 import (
-	"eurocontrol.io/digital-platform-product-deployment/pkg/autoconfig"
+	"eurocontrol.io/demo/egress/pkg/autoconfig"
 	"fmt"
 	"net"
 	"os"
@@ -407,3 +407,147 @@ func assertPanic(t *testing.T, expected string) {
 	require.NotNil(t, r)
 	require.Equal(t, expected, fmt.Sprintf("%v", r))
 }
+
+type timeouts struct {
+	ConnectMillis time.Duration `value:"timeout.connect|100"`
+	ReadSeconds   time.Duration `value:"timeout.read|2"`
+}
+
+type endpoint struct {
+	URL      string `value:"url|http://localhost"`
+	Timeouts timeouts
+}
+
+func TestAutoConfigure_Nested(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	os.Setenv("TIMEOUT_READ", "5")
+	var conf struct {
+		Endpoint endpoint
+	}
+
+	err := autoconfig.AutoConfigure(&conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost", conf.Endpoint.URL)
+	assert.Equal(t, 100*time.Millisecond, conf.Endpoint.Timeouts.ConnectMillis)
+	assert.Equal(t, 5*time.Second, conf.Endpoint.Timeouts.ReadSeconds)
+	assert.Equal(t, "5000000000", viper.GetString("timeout.read"))
+}
+
+func TestAutoConfigure_Embedded(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	os.Setenv("URL", "http://embedded")
+	var conf struct {
+		endpoint
+		Name string `value:"name|embedding"`
+	}
+
+	err := autoconfig.AutoConfigure(&conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "http://embedded", conf.URL)
+	assert.Equal(t, 100*time.Millisecond, conf.Timeouts.ConnectMillis)
+	assert.Equal(t, "embedding", conf.Name)
+}
+
+func TestAutoConfigure_Pointer(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	existing := &timeouts{}
+	var conf struct {
+		Endpoint *endpoint
+		Timeouts *timeouts `prefix:"existing"`
+		Ignored  *struct{ Field string }
+	}
+	conf.Timeouts = existing
+
+	err := autoconfig.AutoConfigure(&conf)
+
+	require.NoError(t, err)
+	require.NotNil(t, conf.Endpoint)
+	assert.Equal(t, "http://localhost", conf.Endpoint.URL)
+	assert.Same(t, existing, conf.Timeouts)
+	assert.Equal(t, 2*time.Second, existing.ReadSeconds)
+	assert.Nil(t, conf.Ignored)
+}
+
+func TestAutoConfigure_Prefix(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	os.Setenv("RAIL_CLIENT_URL", "https://api.irail.be")
+	os.Setenv("RAIL_CLIENT_TIMEOUT_CONNECT", "250")
+	viper.Set("nm.backend.url", "https://nm.example.org")
+	var conf struct {
+		Rail    endpoint  `prefix:"rail.client"`
+		Backend *endpoint `prefix:"nm.backend"`
+		Nested  struct {
+			Inner endpoint `prefix:"inner"`
+		} `prefix:"outer"`
+	}
+
+	err := autoconfig.AutoConfigure(&conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.irail.be", conf.Rail.URL)
+	assert.Equal(t, 250*time.Millisecond, conf.Rail.Timeouts.ConnectMillis)
+	assert.Equal(t, "https://nm.example.org", conf.Backend.URL)
+	assert.Equal(t, 100*time.Millisecond, conf.Backend.Timeouts.ConnectMillis)
+	assert.Equal(t, "http://localhost", conf.Nested.Inner.URL)
+	assert.Equal(t, "http://localhost", viper.GetString("outer.inner.url"))
+	assert.Equal(t, "https://api.irail.be", viper.GetString("rail.client.url"))
+}
+
+type node struct {
+	Name string `value:"node.name|leaf"`
+	Next *node
+}
+
+func TestAutoConfigure_RecursiveType(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	conf := &node{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, "leaf", conf.Name)
+	assert.Nil(t, conf.Next)
+}
+
+func TestAutoConfigure_Err_Nested(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	os.Setenv("SUB_TIMEOUT_CONNECT", "soon")
+	var conf struct {
+		Endpoint endpoint `prefix:"sub"`
+	}
+
+	err := autoconfig.AutoConfigure(&conf)
+
+	assert.EqualError(t, err, "sub.timeout.connect (SUB_TIMEOUT_CONNECT) from environment: error while parsing durationValue value soon for tag sub.timeout.connect|100: time: invalid duration \"soonms\"")
+}
+
+func TestAutoConfigure_Twice(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	viper.Set("timeout.connect", "250")
+	conf := &timeouts{}
+	require.NoError(t, autoconfig.AutoConfigure(conf))
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, conf.ConnectMillis)
+	assert.Equal(t, 2*time.Second, conf.ReadSeconds)
+	assert.Equal(t, "2000000000", viper.GetString("timeout.read"))
+}
+
+func TestAutoConfigure_Twice_ViperSet(t *testing.T) {
+	autoconfig.ClearEnvironment()
+	viper.Set("timeout.connect", "250")
+	conf := &timeouts{}
+	require.NoError(t, autoconfig.AutoConfigure(conf))
+
+	viper.Set("timeout.connect", "500")
+	viper.Set("timeout.read", "3")
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, conf.ConnectMillis, "later viper value seen")
+	assert.Equal(t, 3*time.Second, conf.ReadSeconds, "viper value set over a default seen")
+}
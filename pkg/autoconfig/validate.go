@@ -0,0 +1,233 @@
+package autoconfig
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PropertyError is a property whose value cannot be parsed or breaks a rule of its validate tag.
+type PropertyError struct {
+	Property string
+	Env      string
+	// Source is the source of the value: environment, file followed by its path, viper or default.
+	Source string
+	Value  string
+	Err    error
+}
+
+func (e *PropertyError) Error() string {
+	return fmt.Sprintf("%s (%s) from %s: %v", e.Property, e.Env, e.Source, e.Err)
+}
+
+func (e *PropertyError) Unwrap() error {
+	return e.Err
+}
+
+// Errors lists the properties of a configuration that are not valid.
+type Errors []*PropertyError
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "\n- " + err.Error()
+	}
+	return fmt.Sprintf("%d invalid properties:%s", len(e), strings.Join(lines, ""))
+}
+
+var hostnameFormat = regexp.MustCompile(`^([a-zA-Z\d]([a-zA-Z\d\-]{0,61}[a-zA-Z\d])?)(\.[a-zA-Z\d]([a-zA-Z\d\-]{0,61}[a-zA-Z\d])?)*$`)
+
+// validateValue checks the configured value of the field against the rules of its validate tag. It returns a
+// *PropertyError when a rule is broken, or another error when the tag itself is not valid.
+func validateValue(fValue reflect.Value, fType reflect.StructField, r resolvedValue) error {
+	tag := fType.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+	for _, rule := range splitRules(tag) {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		err := checkRule(fValue, fType, name, arg)
+		if err != nil {
+			if _, ok := err.(ruleError); ok {
+				return r.invalid(err)
+			}
+			return fmt.Errorf("invalid validate tag %q of %s: %v", tag, r.property, err)
+		}
+	}
+	return nil
+}
+
+// splitRules splits the comma separated rules, a regex rule taking the rest of the tag so that its
+// expression can hold commas.
+func splitRules(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			return append(rules, tag)
+		}
+		rule := tag
+		tag = ""
+		if i := strings.Index(rule, ","); i >= 0 {
+			rule, tag = rule[:i], rule[i+1:]
+		}
+		rules = append(rules, strings.TrimSpace(rule))
+	}
+	return rules
+}
+
+// ruleError is a value breaking a rule, as opposed to a rule that is not valid.
+type ruleError string
+
+func (e ruleError) Error() string {
+	return string(e)
+}
+
+func broken(format string, args ...interface{}) error {
+	return ruleError(fmt.Sprintf(format, args...))
+}
+
+func checkRule(fValue reflect.Value, fType reflect.StructField, name, arg string) error {
+	kind := fType.Type.String()
+	switch name {
+	case "required", "nonempty":
+		if kind == "[]string" {
+			if fValue.Len() == 0 {
+				return broken("must not be empty")
+			}
+			return nil
+		}
+		if name == "required" && kind == "string" {
+			if fValue.String() == "" {
+				return broken("is required")
+			}
+			return nil
+		}
+	case "min", "max":
+		switch kind {
+		case "int", "int8", "int16", "int32", "int64":
+			bound, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s expects an integer: %v", name, err)
+			}
+			return checkBound(name, fValue.Int() < bound, fValue.Int() > bound, bound)
+		case "time.Duration":
+			bound, err := time.ParseDuration(arg + getUnitFromFieldName(fType.Name))
+			if err != nil {
+				bound, err = time.ParseDuration(arg)
+			}
+			if err != nil {
+				return fmt.Errorf("%s expects a duration: %v", name, err)
+			}
+			d := time.Duration(fValue.Int())
+			return checkBound(name, d < bound, d > bound, bound)
+		}
+	case "port":
+		switch kind {
+		case "int", "int16", "int32", "int64":
+			if fValue.Int() < 1 || fValue.Int() > 65535 {
+				return broken("must be a port, between 1 and 65535")
+			}
+			return nil
+		}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		if len(allowed) == 0 {
+			return fmt.Errorf("oneof expects values")
+		}
+		switch kind {
+		case "int", "int8", "int16", "int32", "int64":
+			return checkStrings([]string{strconv.FormatInt(fValue.Int(), 10)}, func(s string) error {
+				return checkOneOf(s, allowed)
+			})
+		}
+		return checkStrings(stringValues(fValue, kind), func(s string) error {
+			return checkOneOf(s, allowed)
+		})
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return fmt.Errorf("regex expects a regular expression: %v", err)
+		}
+		return checkStrings(stringValues(fValue, kind), func(s string) error {
+			if !re.MatchString(s) {
+				return broken("must match %s", arg)
+			}
+			return nil
+		})
+	case "url":
+		return checkStrings(stringValues(fValue, kind), func(s string) error {
+			u, err := url.Parse(s)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return broken("must be an absolute URL")
+			}
+			return nil
+		})
+	case "hostname":
+		return checkStrings(stringValues(fValue, kind), func(s string) error {
+			if len(s) > 253 || !hostnameFormat.MatchString(s) {
+				return broken("must be a hostname")
+			}
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown rule %s", name)
+	}
+	return fmt.Errorf("rule %s not supported for %s", name, kind)
+}
+
+func checkBound(name string, below, above bool, bound interface{}) error {
+	if name == "min" && below {
+		return broken("must be at least %v", bound)
+	}
+	if name == "max" && above {
+		return broken("must be at most %v", bound)
+	}
+	return nil
+}
+
+func checkOneOf(s string, allowed []string) error {
+	for _, a := range allowed {
+		if s == a {
+			return nil
+		}
+	}
+	return broken("must be one of %s", strings.Join(allowed, ", "))
+}
+
+// stringValues returns the values of a string or a string slice field, nil for the other types.
+func stringValues(fValue reflect.Value, kind string) []string {
+	switch kind {
+	case "string":
+		return []string{fValue.String()}
+	case "[]string":
+		return fValue.Interface().([]string)
+	}
+	return nil
+}
+
+// checkStrings checks the non-empty values, use required to reject an empty one.
+func checkStrings(values []string, check func(string) error) error {
+	if values == nil {
+		return fmt.Errorf("rule not supported for this type")
+	}
+	for _, s := range values {
+		if s == "" {
+			continue
+		}
+		err := check(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package autoconfig_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/autoconfig"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validatedConf struct {
+	Port          int32         `value:"server.port|8000" validate:"port"`
+	Workers       int           `value:"server.workers|4" validate:"min=1,max=64"`
+	TimeoutMillis time.Duration `value:"server.timeout|5000" validate:"min=100,max=1m"`
+	BaseURL       string        `value:"rail.client.base-url|https://api.irail.be" validate:"required,url"`
+	Host          string        `value:"rail.client.host" validate:"hostname"`
+	Level         string        `value:"log.level|info" validate:"oneof=debug info warn error"`
+	Version       string        `value:"app.version|1.0.0" validate:"regex=^[0-9]+[.][0-9]{1,3}([.][0-9]+)?$"`
+	Statuses      []string      `value:"rail.client.retry.statuses|502 503" validate:"nonempty,regex=^[1-5][0-9][0-9]$"`
+	NoProxy       []string      `value:"egress.proxy.no-proxy" validate:"hostname"`
+}
+
+func TestAutoConfigure_Validate(t *testing.T) {
+	clearEnvironment(t)
+	os.Setenv("RAIL_CLIENT_HOST", "api.irail.be")
+	os.Setenv("EGRESS_PROXY_NOPROXY", "localhost kubernetes.default.svc")
+	conf := &validatedConf{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(8000), conf.Port)
+	assert.Equal(t, 5*time.Second, conf.TimeoutMillis)
+}
+
+func TestAutoConfigure_Err_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   string
+		value string
+		want  string
+	}{
+		{"port", "SERVER_PORT", "0", "must be a port, between 1 and 65535"},
+		{"port too high", "SERVER_PORT", "65536", "must be a port, between 1 and 65535"},
+		{"min int", "SERVER_WORKERS", "0", "must be at least 1"},
+		{"max int", "SERVER_WORKERS", "65", "must be at most 64"},
+		{"min duration", "SERVER_TIMEOUT", "99", "must be at least 100ms"},
+		{"max duration", "SERVER_TIMEOUT", "60001", "must be at most 1m0s"},
+		{"required", "RAIL_CLIENT_BASEURL", "", ""},
+		{"url", "RAIL_CLIENT_BASEURL", "https//api.irail.be", "must be an absolute URL"},
+		{"hostname", "RAIL_CLIENT_HOST", "api_irail.be", "must be a hostname"},
+		{"oneof", "LOG_LEVEL", "verbose", "must be one of debug, info, warn, error"},
+		{"regex", "APP_VERSION", "1.0.0-SNAPSHOT", `must match ^[0-9]+[.][0-9]{1,3}([.][0-9]+)?$`},
+		{"regex slice", "RAIL_CLIENT_RETRY_STATUSES", "502 5O3", `must match ^[1-5][0-9][0-9]$`},
+		{"hostname slice", "EGRESS_PROXY_NOPROXY", "localhost -invalid", "must be a hostname"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvironment(t)
+			os.Setenv(tt.env, tt.value)
+
+			err := autoconfig.AutoConfigure(&validatedConf{})
+
+			if tt.want == "" {
+				assert.NoError(t, err, "empty variable falls back to the default")
+				return
+			}
+			var invalid autoconfig.Errors
+			require.True(t, errors.As(err, &invalid), "%v", err)
+			require.Len(t, invalid, 1)
+			assert.Equal(t, tt.env, invalid[0].Env)
+			assert.Equal(t, autoconfig.SourceEnv, invalid[0].Source)
+			assert.Equal(t, tt.value, invalid[0].Value)
+			assert.EqualError(t, invalid[0].Err, tt.want)
+		})
+	}
+}
+
+func TestAutoConfigure_Err_Aggregated(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	path := writeFile(t, dir, "application.yaml", "log.level: verbose\nserver.workers: many\n")
+	os.Setenv(autoconfig.ConfigFileEnv, path)
+	os.Setenv("SERVER_PORT", "0")
+	viper.Set("rail.client.base-url", "irail")
+	conf := &validatedConf{}
+
+	err := autoconfig.AutoConfigure(conf)
+
+	assert.EqualError(t, err, `4 invalid properties:
+- server.port (SERVER_PORT) from environment: must be a port, between 1 and 65535
+- server.workers (SERVER_WORKERS) from file `+path+`: error while parsing int value many for tag server.workers|4: strconv.ParseInt: parsing "many": invalid syntax
+- rail.client.base-url (RAIL_CLIENT_BASEURL) from viper: must be an absolute URL
+- log.level (LOG_LEVEL) from file `+path+`: must be one of debug, info, warn, error`)
+	assert.Equal(t, 5*time.Second, conf.TimeoutMillis, "valid properties still configured")
+}
+
+func TestAutoConfigure_Err_Validate_Default(t *testing.T) {
+	clearEnvironment(t)
+	var conf struct {
+		Name string `value:"app.name" validate:"required"`
+	}
+
+	err := autoconfig.AutoConfigure(&conf)
+
+	assert.EqualError(t, err, "app.name (APP_NAME) from default: is required")
+}
+
+func TestAutoConfigure_Err_Validate_Tag(t *testing.T) {
+	tests := []struct {
+		name string
+		conf interface{}
+		want string
+	}{
+		{"unknown", &struct {
+			F string `value:"f|a" validate:"ascii"`
+		}{}, `invalid validate tag "ascii" of f: unknown rule ascii`},
+		{"unsupported", &struct {
+			F bool `value:"f|true" validate:"min=1"`
+		}{}, `invalid validate tag "min=1" of f: rule min not supported for bool`},
+		{"bound", &struct {
+			F int `value:"f|1" validate:"max=ten"`
+		}{}, `invalid validate tag "max=ten" of f: max expects an integer: strconv.ParseInt: parsing "ten": invalid syntax`},
+		{"regex", &struct {
+			F string `value:"f|a" validate:"regex=("`
+		}{}, "invalid validate tag \"regex=(\" of f: regex expects a regular expression: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvironment(t)
+
+			err := autoconfig.AutoConfigure(tt.conf)
+
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestWatch_Err_Validate(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	writeFile(t, dir, "application.yaml", "server.port: 8080\n")
+	os.Setenv(autoconfig.ConfigPathEnv, dir)
+	conf := &validatedConf{}
+	w, err := autoconfig.Watch(conf, func(interface{}, []autoconfig.Change) {
+		t.Error("invalid configuration notified")
+	}, autoconfig.WithDebounce(time.Hour))
+	require.NoError(t, err)
+	defer w.Close()
+
+	writeFile(t, dir, "application.yaml", "server.port: 0\n")
+
+	assert.EqualError(t, w.Reload(), "server.port (SERVER_PORT) from file "+filepath.Join(dir, "application.yaml")+": must be a port, between 1 and 65535")
+	assert.Same(t, conf, w.Current())
+	assert.Equal(t, "8080", viper.GetString("server.port"), "invalid value not stored")
+	other := &validatedConf{}
+	require.NoError(t, autoconfig.AutoConfigure(other), "invalid file not used")
+	assert.Equal(t, int32(8080), other.Port)
+}
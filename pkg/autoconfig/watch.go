@@ -0,0 +1,219 @@
+package autoconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Change is a property whose value changed when the configuration was reloaded.
+type Change struct {
+	Property string
+	Old      interface{}
+	New      interface{}
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Property, c.Old, c.New)
+}
+
+// WatchOption customizes the Watcher started by Watch.
+type WatchOption func(*Watcher)
+
+// WithErrorHandler sets the function called when the configuration cannot be reloaded, the previous
+// configuration being kept. Without it, the errors are ignored.
+func WithErrorHandler(onError func(error)) WatchOption {
+	return func(w *Watcher) {
+		w.onError = onError
+	}
+}
+
+// WithDebounce sets how long the Watcher waits for the file events to settle before reloading, a ConfigMap
+// update for instance being made of several events. It is 100 milliseconds by default.
+func WithDebounce(debounce time.Duration) WatchOption {
+	return func(w *Watcher) {
+		w.debounce = debounce
+	}
+}
+
+// Watcher reloads a configuration when its files change. See Watch.
+type Watcher struct {
+	sync.Mutex
+	current reflect.Value
+	// reloading serializes the reloads along with their onChange calls.
+	reloading sync.Mutex
+	values    map[string]interface{}
+	onChange  func(cfg interface{}, changes []Change)
+	onError   func(error)
+	debounce  time.Duration
+	fs        *fsnotify.Watcher
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// Watch configures cfg, a pointer to a struct, then watches the configuration files and the CONFIG_PATH
+// directories. When they change, the files are reloaded and a fresh copy of the struct is configured: cfg
+// itself is never modified afterwards, so that it can be read without synchronization. When properties
+// changed, onChange is called with the fresh copy and the changes, sorted by property. The calls are
+// sequential, from the Watcher goroutine.
+//
+// The directories of the files are watched rather than the files, so that the atomic symlink swaps of the
+// ConfigMap volumes are seen. The environment variables are read again on reload, but changing them does
+// not trigger one.
+func Watch(cfg interface{}, onChange func(cfg interface{}, changes []Change), opts ...WatchOption) (*Watcher, error) {
+	c := newConfigurer()
+	c.values = map[string]interface{}{}
+	err := c.configure(reflect.ValueOf(cfg).Elem())
+	if err != nil {
+		return nil, err
+	}
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		current:  reflect.ValueOf(cfg).Elem(),
+		values:   c.values,
+		onChange: onChange,
+		onError:  func(error) {},
+		debounce: 100 * time.Millisecond,
+		fs:       fs,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	err = w.watchDirectories()
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// watchDirectories watches the directories of the loaded files and the CONFIG_PATH directories, where an
+// application file or a profile overlay can appear.
+func (w *Watcher) watchDirectories() error {
+	paths, err := ConfigFiles()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{}
+	for _, path := range paths {
+		dirs[filepath.Dir(path)] = true
+	}
+	for _, dir := range splitList(os.Getenv(ConfigPathEnv)) {
+		_, err := os.Stat(dir)
+		if err == nil {
+			dirs[filepath.Clean(dir)] = true
+		}
+	}
+	for dir := range dirs {
+		err := w.fs.Add(dir)
+		if err != nil {
+			return fmt.Errorf("unable to watch configuration directory %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.stopped)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-w.done:
+			timer.Stop()
+			return
+		case _, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			timer.Reset(w.debounce)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			w.onError(err)
+		case <-timer.C:
+			err := w.Reload()
+			if err != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// Reload reloads the configuration files and configures a fresh copy of the struct, calling onChange when
+// properties changed. The previous configuration is kept when it fails, invalid properties included: the
+// reloaded files and values are only used by the next configurations once the fresh copy is valid.
+func (w *Watcher) Reload() error {
+	w.reloading.Lock()
+	defer w.reloading.Unlock()
+	source := &fileSource{}
+	err := source.reload()
+	if err != nil {
+		return err
+	}
+	fresh := reflect.New(w.current.Type())
+	c := newConfigurer()
+	c.files = source
+	c.values = map[string]interface{}{}
+	c.pending = map[string]interface{}{}
+	err = c.configure(fresh.Elem())
+	if err != nil {
+		return err
+	}
+	files.replace(source)
+	for property, value := range c.pending {
+		vipUpdate.set(property, value)
+	}
+	changes := diff(w.values, c.values)
+	if len(changes) == 0 {
+		return nil
+	}
+	w.Lock()
+	w.current = fresh.Elem()
+	w.Unlock()
+	w.values = c.values
+	w.onChange(fresh.Interface(), changes)
+	return nil
+}
+
+// Current returns the latest configuration, cfg until the first change.
+func (w *Watcher) Current() interface{} {
+	w.Lock()
+	defer w.Unlock()
+	return w.current.Addr().Interface()
+}
+
+// Close stops watching the configuration files.
+func (w *Watcher) Close() error {
+	close(w.done)
+	err := w.fs.Close()
+	<-w.stopped
+	return err
+}
+
+func diff(old, new map[string]interface{}) []Change {
+	var changes []Change
+	for property, value := range new {
+		previous, ok := old[property]
+		if !ok || !reflect.DeepEqual(previous, value) {
+			changes = append(changes, Change{Property: property, Old: previous, New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Property < changes[j].Property
+	})
+	return changes
+}
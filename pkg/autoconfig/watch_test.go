@@ -0,0 +1,141 @@
+package autoconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eurocontrol.io/demo/egress/pkg/autoconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type notification struct {
+	conf    *fileConf
+	changes []autoconfig.Change
+}
+
+// watch watches conf, the notifications being sent to the returned channel.
+func watch(t *testing.T, conf *fileConf, opts ...autoconfig.WatchOption) (*autoconfig.Watcher, chan notification) {
+	notifications := make(chan notification, 10)
+	w, err := autoconfig.Watch(conf, func(cfg interface{}, changes []autoconfig.Change) {
+		notifications <- notification{cfg.(*fileConf), changes}
+	}, append([]autoconfig.WatchOption{autoconfig.WithDebounce(10 * time.Millisecond)}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { w.Close() })
+	return w, notifications
+}
+
+// replaceFile replaces the file in one go, so that the watcher never reads it half written.
+func replaceFile(t *testing.T, dir, name, content string) {
+	tmp := writeFile(t, dir, "."+name+".tmp", content)
+	require.NoError(t, os.Rename(tmp, filepath.Join(dir, name)))
+}
+
+func next(t *testing.T, notifications chan notification) notification {
+	select {
+	case n := <-notifications:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change notified")
+		return notification{}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	path := writeFile(t, dir, "application.yaml", "rail.client.timeout: 2500\nrail.client.language: nl\n")
+	os.Setenv(autoconfig.ConfigPathEnv, dir)
+	os.Setenv("RAIL_CLIENT_LANGUAGE", "de")
+	conf := &fileConf{}
+	w, notifications := watch(t, conf)
+	require.Equal(t, 2500*time.Millisecond, conf.TimeoutMillis)
+
+	replaceFile(t, dir, "application.yaml", "rail.client.timeout: 4000\nrail.client.language: fr\nrail.client.enabled: true\n")
+
+	n := next(t, notifications)
+	assert.Equal(t, []autoconfig.Change{
+		{Property: "rail.client.enabled", Old: false, New: true},
+		{Property: "rail.client.timeout", Old: 2500 * time.Millisecond, New: 4000 * time.Millisecond},
+	}, n.changes)
+	assert.Equal(t, "rail.client.timeout: 2.5s -> 4s", n.changes[1].String())
+	assert.Equal(t, 4000*time.Millisecond, n.conf.TimeoutMillis)
+	assert.Equal(t, "de", n.conf.Language, "env still beats file")
+	assert.Equal(t, 2500*time.Millisecond, conf.TimeoutMillis, "watched struct untouched")
+	assert.Same(t, n.conf, w.Current())
+	files, err := autoconfig.ConfigFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, files)
+}
+
+func TestWatch_ConfigMapSwap(t *testing.T) {
+	// A ConfigMap volume links its files to the ..data symlink, which is swapped on update.
+	dir := t.TempDir()
+	clearEnvironment(t)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..v1"), 0700))
+	writeFile(t, filepath.Join(dir, "..v1"), "application.yaml", "rail.client.base-url: https://v1.example.org\n")
+	require.NoError(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "application.yaml"), filepath.Join(dir, "application.yaml")))
+	os.Setenv(autoconfig.ConfigPathEnv, dir)
+	conf := &fileConf{}
+	_, notifications := watch(t, conf)
+	require.Equal(t, "https://v1.example.org", conf.BaseURL)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..v2"), 0700))
+	writeFile(t, filepath.Join(dir, "..v2"), "application.yaml", "rail.client.base-url: https://v2.example.org\n")
+	require.NoError(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	n := next(t, notifications)
+	assert.Equal(t, []autoconfig.Change{
+		{Property: "rail.client.base-url", Old: "https://v1.example.org", New: "https://v2.example.org"},
+	}, n.changes)
+}
+
+func TestWatch_Reload(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	os.Setenv(autoconfig.ConfigFileEnv, writeFile(t, dir, "service.yaml", "rail.client.language: nl\n"))
+	conf := &fileConf{}
+	w, notifications := watch(t, conf, autoconfig.WithDebounce(time.Hour))
+	require.Equal(t, "nl", conf.Language)
+
+	require.NoError(t, w.Reload())
+	assert.Empty(t, notifications, "nothing changed")
+
+	writeFile(t, dir, "service.yaml", "rail.client.base-url: https://api.irail.be\n")
+	writeFile(t, dir, "service-dev.yaml", "rail.client.timeout: 3000\n")
+	os.Setenv(autoconfig.AppProfilesEnv, "dev")
+	require.NoError(t, w.Reload())
+
+	n := next(t, notifications)
+	assert.Equal(t, []autoconfig.Change{
+		{Property: "rail.client.base-url", Old: "http://localhost", New: "https://api.irail.be"},
+		{Property: "rail.client.language", Old: "nl", New: "en"},
+		{Property: "rail.client.timeout", Old: time.Second, New: 3 * time.Second},
+	}, n.changes, "removed property back to its default")
+}
+
+func TestWatch_Err_Reload(t *testing.T) {
+	dir := t.TempDir()
+	clearEnvironment(t)
+	path := writeFile(t, dir, "application.json", `{"rail.client.language": "nl"}`)
+	os.Setenv(autoconfig.ConfigPathEnv, dir)
+	errs := make(chan error, 10)
+	conf := &fileConf{}
+	w, notifications := watch(t, conf, autoconfig.WithErrorHandler(func(err error) { errs <- err }))
+
+	writeFile(t, dir, "application.json", `{"rail.client.language": `)
+
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "unable to read configuration file "+path+": While parsing config: unexpected end of JSON input")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error reported")
+	}
+	assert.Empty(t, notifications)
+	assert.Same(t, conf, w.Current())
+	assert.NoError(t, autoconfig.AutoConfigure(&fileConf{}), "previous files kept")
+}
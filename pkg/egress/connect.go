@@ -0,0 +1,71 @@
+// Package egress reaches the upstreams of the service out of the cluster,
+// directly or through the egress proxy, and diagnoses why it cannot.
+package egress
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// connect asks the proxy at the other end of conn, named proxy in errors, to
+// open a tunnel to the given host:port address. The returned connection reads
+// from the buffered reader of the proxy response, the proxy being allowed to
+// send the first bytes of the tunnel along with it. A proxy answering with
+// another status than 200 fails with a ProxyRefusedError, the response being
+// returned too.
+func connect(ctx context.Context, conn net.Conn, proxy, address string, header http.Header) (net.Conn, *http.Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: header,
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, res, &ProxyRefusedError{Proxy: proxy, Target: address, StatusCode: res.StatusCode, Status: res.Status}
+	}
+	return &bufferedConn{Conn: conn, r: br}, res, nil
+}
+
+// bufferedConn is a connection whose first bytes were already buffered.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// hostPort returns the host:port address of the URL, the port defaulting to
+// the one of its scheme.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
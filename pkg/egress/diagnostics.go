@@ -0,0 +1,374 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DiagnosticsConfig bounds the time given to the probes of an upstream.
+type DiagnosticsConfig struct {
+	TimeoutMillis time.Duration `value:"diagnostics.egress.timeout|5000"`
+}
+
+// Upstream is a service reached out of the cluster, through the given proxy
+// when it applies.
+type Upstream struct {
+	Name  string
+	URL   string
+	Proxy *Proxy
+}
+
+// StepStatus is the outcome of a step of a probe. The steps after a failed
+// one are skipped.
+type StepStatus string
+
+const (
+	StepOK      StepStatus = "ok"
+	StepFailed  StepStatus = "failed"
+	StepSkipped StepStatus = "skipped"
+)
+
+// Step is a step of a probe, with what it found out.
+type Step struct {
+	Name           string        `json:"name"`
+	Status         StepStatus    `json:"status"`
+	DurationMillis float64       `json:"durationMillis"`
+	Error          string        `json:"error,omitempty"`
+	Addresses      []string      `json:"addresses,omitempty"`
+	RemoteAddress  string        `json:"remoteAddress,omitempty"`
+	SNI            string        `json:"sni,omitempty"`
+	TLSVersion     string        `json:"tlsVersion,omitempty"`
+	Certificates   []Certificate `json:"certificates,omitempty"`
+	StatusCode     int           `json:"statusCode,omitempty"`
+}
+
+// Certificate describes a certificate of the chain presented by upstream.
+type Certificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames,omitempty"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// Probe is the chain of steps reaching an upstream one way, direct or through
+// the proxy. Used tells whether it is the way the service takes.
+type Probe struct {
+	Via        string `json:"via"`
+	Proxy      string `json:"proxy,omitempty"`
+	Used       bool   `json:"used"`
+	OK         bool   `json:"ok"`
+	FailedStep string `json:"failedStep,omitempty"`
+	Steps      []Step `json:"steps"`
+}
+
+// Report is the diagnosis of an upstream. It is OK when the way the service
+// takes works.
+type Report struct {
+	Upstream string  `json:"upstream"`
+	URL      string  `json:"url"`
+	OK       bool    `json:"ok"`
+	Probes   []Probe `json:"probes"`
+}
+
+// Diagnostics probes the upstreams step by step, so that a NetworkPolicy
+// denial, showing as a failed TCP connect, can be told apart from an Istio
+// ServiceEntry misconfiguration, showing as a failed TLS handshake or HTTP
+// exchange, or from a proxy refusing the CONNECT. The direct way is always
+// probed, the proxy way only when the upstream has one.
+type Diagnostics struct {
+	config    DiagnosticsConfig
+	upstreams []Upstream
+	resolver  *net.Resolver
+	dialer    *net.Dialer
+	tlsConfig *tls.Config
+}
+
+// NewDiagnostics returns the diagnostics of the given upstreams.
+func NewDiagnostics(config DiagnosticsConfig, upstreams ...Upstream) *Diagnostics {
+	return &Diagnostics{
+		config:    config,
+		upstreams: upstreams,
+		resolver:  net.DefaultResolver,
+		dialer:    &net.Dialer{},
+		tlsConfig: &tls.Config{},
+	}
+}
+
+// Diagnose probes all the upstreams concurrently.
+func (d *Diagnostics) Diagnose(ctx context.Context) []Report {
+	reports := make([]Report, len(d.upstreams))
+	var wg sync.WaitGroup
+	for i, upstream := range d.upstreams {
+		wg.Add(1)
+		go func(i int, upstream Upstream) {
+			defer wg.Done()
+			reports[i] = d.diagnose(ctx, upstream)
+		}(i, upstream)
+	}
+	wg.Wait()
+	return reports
+}
+
+func (d *Diagnostics) diagnose(ctx context.Context, upstream Upstream) Report {
+	report := Report{Upstream: upstream.Name, URL: upstream.URL}
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		report.Probes = []Probe{{Via: "direct", Steps: []Step{{Name: "url", Status: StepFailed, Error: err.Error()}}}}
+		return report
+	}
+	proxyURL := upstream.Proxy.URLFor(target)
+	direct := d.direct(ctx, target)
+	direct.Used = proxyURL == nil
+	report.Probes = append(report.Probes, direct)
+	if proxyURL != nil {
+		proxied := d.proxied(ctx, target, upstream.Proxy, proxyURL)
+		proxied.Used = true
+		report.Probes = append(report.Probes, proxied)
+	}
+	for _, probe := range report.Probes {
+		if probe.Used {
+			report.OK = probe.OK
+		}
+	}
+	return report
+}
+
+func (d *Diagnostics) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.config.TimeoutMillis > 0 {
+		return context.WithTimeout(ctx, d.config.TimeoutMillis)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (d *Diagnostics) direct(ctx context.Context, target *url.URL) Probe {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+	run := &probeRun{probe: Probe{Via: "direct"}}
+	var addresses []string
+	var conn net.Conn
+	run.step("dns", func(s *Step) (err error) {
+		addresses, err = d.resolver.LookupHost(ctx, target.Hostname())
+		s.Addresses = addresses
+		return err
+	})
+	run.step("tcp", func(s *Step) (err error) {
+		_, port, _ := net.SplitHostPort(hostPort(target))
+		conn, err = d.dial(ctx, net.JoinHostPort(addresses[0], port))
+		if err == nil {
+			s.RemoteAddress = conn.RemoteAddr().String()
+		}
+		return err
+	})
+	if conn != nil {
+		defer conn.Close()
+	}
+	d.exchange(ctx, run, conn, target)
+	return run.done()
+}
+
+func (d *Diagnostics) proxied(ctx context.Context, target *url.URL, proxy *Proxy, proxyURL *url.URL) Probe {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+	name := redacted(proxyURL.String())
+	run := &probeRun{probe: Probe{Via: "proxy", Proxy: name}}
+	var addresses []string
+	var conn net.Conn
+	run.step("proxy-dns", func(s *Step) (err error) {
+		addresses, err = d.resolver.LookupHost(ctx, proxyURL.Hostname())
+		s.Addresses = addresses
+		return err
+	})
+	run.step("proxy-tcp", func(s *Step) (err error) {
+		_, port, _ := net.SplitHostPort(hostPort(proxyURL))
+		conn, err = d.dial(ctx, net.JoinHostPort(addresses[0], port))
+		if err == nil {
+			s.RemoteAddress = conn.RemoteAddr().String()
+		}
+		return err
+	})
+	if conn != nil {
+		defer conn.Close()
+	}
+	run.step("connect", func(s *Step) error {
+		if proxyURL.Scheme != "http" {
+			return fmt.Errorf("%s proxy not probed, only http proxies open CONNECT tunnels", proxyURL.Scheme)
+		}
+		tunnel, res, err := connect(ctx, conn, name, hostPort(target), proxy.header(proxyURL))
+		if res != nil {
+			s.StatusCode = res.StatusCode
+		}
+		if err == nil {
+			conn = tunnel
+		}
+		return err
+	})
+	d.exchange(ctx, run, conn, target)
+	return run.done()
+}
+
+func (d *Diagnostics) dial(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// exchange runs the TLS handshake, for an HTTPS upstream, and the HTTP probe
+// over conn.
+func (d *Diagnostics) exchange(ctx context.Context, run *probeRun, conn net.Conn, target *url.URL) {
+	if target.Scheme == "https" {
+		run.step("tls", func(s *Step) error {
+			config := d.tlsConfig.Clone()
+			config.ServerName = target.Hostname()
+			s.SNI = config.ServerName
+			tlsConn := tls.Client(conn, config)
+			err := tlsConn.Handshake()
+			if err != nil {
+				s.Certificates = errorCertificates(err)
+				return err
+			}
+			state := tlsConn.ConnectionState()
+			s.TLSVersion = tlsVersion(state.Version)
+			s.Certificates = certificates(state.PeerCertificates)
+			conn = tlsConn
+			return nil
+		})
+	}
+	run.step("http", func(s *Step) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.Close = true
+		err = req.Write(conn)
+		if err != nil {
+			return err
+		}
+		res, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		s.StatusCode = res.StatusCode
+		if res.StatusCode >= 500 {
+			return fmt.Errorf("upstream answered %s", res.Status)
+		}
+		return nil
+	})
+}
+
+// probeRun runs the steps of a probe in order, skipping the steps after a
+// failed one.
+type probeRun struct {
+	probe  Probe
+	failed bool
+}
+
+func (r *probeRun) step(name string, fn func(s *Step) error) {
+	s := Step{Name: name, Status: StepSkipped}
+	if !r.failed {
+		start := time.Now()
+		err := fn(&s)
+		s.DurationMillis = float64(time.Since(start).Microseconds()) / 1000
+		s.Status = StepOK
+		if err != nil {
+			s.Status = StepFailed
+			s.Error = err.Error()
+			r.failed = true
+			r.probe.FailedStep = name
+		}
+	}
+	r.probe.Steps = append(r.probe.Steps, s)
+}
+
+func (r *probeRun) done() Probe {
+	r.probe.OK = !r.failed
+	return r.probe
+}
+
+func certificates(chain []*x509.Certificate) []Certificate {
+	certs := make([]Certificate, 0, len(chain))
+	for _, cert := range chain {
+		certs = append(certs, Certificate{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			DNSNames:  cert.DNSNames,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+		})
+	}
+	return certs
+}
+
+// errorCertificates returns the certificate a verification error is about,
+// which tells who answered the handshake when it is not upstream.
+func errorCertificates(err error) []Certificate {
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) && unknownAuthority.Cert != nil {
+		return certificates([]*x509.Certificate{unknownAuthority.Cert})
+	}
+	var hostname x509.HostnameError
+	if errors.As(err, &hostname) && hostname.Certificate != nil {
+		return certificates([]*x509.Certificate{hostname.Certificate})
+	}
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Cert != nil {
+		return certificates([]*x509.Certificate{invalid.Cert})
+	}
+	return nil
+}
+
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// redacted returns the URL without its password, to be reported.
+func redacted(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+func (d *Diagnostics) egress() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(d.Diagnose(r.Context()))
+	}
+}
+
+func (d *Diagnostics) AddRoute(router *mux.Router) {
+	router.HandleFunc("/diagnostics/egress", d.egress()).Methods("GET")
+}
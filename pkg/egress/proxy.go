@@ -0,0 +1,272 @@
+package egress
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxyConfig is the configuration of the egress proxy, the squid of
+// deploy-proxy for instance. The upstreams are reached through HTTP CONNECT
+// tunnels opened by the proxy, which is reached directly.
+//
+// An empty URL falls back to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, and "direct" disables the proxy. The credentials file holds the
+// user:password of the proxy basic authentication, typically mounted from a
+// Secret; credentials given in the URL are used otherwise. NoProxy lists the
+// hosts reached directly: host names matching their subdomains too, IP
+// addresses, CIDR ranges, or * for all.
+type ProxyConfig struct {
+	URL             string   `value:"egress.proxy.url"`
+	CredentialsFile string   `value:"egress.proxy.credentials-file"`
+	NoProxy         []string `value:"egress.proxy.no-proxy"`
+}
+
+// Direct is the proxy URL reaching the upstreams without proxy.
+const Direct = "direct"
+
+// ProxyUnreachableError tells that the egress proxy could not be connected
+// to, a NetworkPolicy denying the egress to the proxy for instance.
+type ProxyUnreachableError struct {
+	Proxy string
+	Err   error
+}
+
+func (e *ProxyUnreachableError) Error() string {
+	return fmt.Sprintf("proxy %s unreachable: %v", e.Proxy, e.Err)
+}
+
+func (e *ProxyUnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// ProxyRefusedError tells that the egress proxy refused to open a tunnel to
+// upstream: 403 when its rules deny the target, 407 when the credentials are
+// missing or wrong.
+type ProxyRefusedError struct {
+	Proxy      string
+	Target     string
+	StatusCode int
+	Status     string
+}
+
+func (e *ProxyRefusedError) Error() string {
+	return fmt.Sprintf("proxy %s refused CONNECT to %s: %s", e.Proxy, e.Target, e.Status)
+}
+
+// Retryable tells whether the proxy may accept the same tunnel later, which
+// it does not when its rules or the credentials are at fault.
+func (e *ProxyRefusedError) Retryable() bool {
+	return e.StatusCode >= 500
+}
+
+// Proxy decides how the upstreams are reached, and reaches them. A nil proxy
+// reaches them directly.
+type Proxy struct {
+	url *url.URL
+	// environment resolves the proxy of a request from the environment
+	// variables, nil when the proxy is not the one of the environment.
+	environment func(*http.Request) (*url.URL, error)
+	// user holds the credentials of the proxy, nil when they are the ones
+	// of the proxy URL if any.
+	user    *url.Userinfo
+	noProxy []string
+	dialer  *net.Dialer
+}
+
+// EnvironmentProxy returns the proxy given by the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables, which Go uses by default. The variable is
+// picked by the scheme of the target, and the proxy can be an http, https or
+// socks5 one: the transport reaches it itself, see Configure.
+func EnvironmentProxy() *Proxy {
+	return &Proxy{environment: http.ProxyFromEnvironment, dialer: &net.Dialer{}}
+}
+
+// NewProxy returns the proxy of the configuration. It fails when the URL is
+// invalid or the credentials file cannot be read.
+func NewProxy(config ProxyConfig) (*Proxy, error) {
+	p, err := EnvironmentProxy().Override(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	p.noProxy = config.NoProxy
+	if config.CredentialsFile != "" {
+		b, err := os.ReadFile(config.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("proxy credentials not read: %v", err)
+		}
+		credentials := strings.TrimSpace(string(b))
+		i := strings.Index(credentials, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid proxy credentials in %s, user:password expected", config.CredentialsFile)
+		}
+		p.user = url.UserPassword(credentials[:i], credentials[i+1:])
+	}
+	return p, nil
+}
+
+// Override returns the proxy of an upstream whose own proxy URL is rawURL.
+// An empty URL keeps p, "direct" disables the proxy, and any other URL
+// replaces the one of p, keeping its no-proxy list, and its credentials unless
+// the URL has some.
+func (p *Proxy) Override(rawURL string) (*Proxy, error) {
+	switch rawURL {
+	case "":
+		return p, nil
+	case Direct:
+		return &Proxy{dialer: p.dialer}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s: %v", rawURL, err)
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %s, http://host:port expected", rawURL)
+	}
+	o := &Proxy{url: u, user: p.user, noProxy: p.noProxy, dialer: p.dialer}
+	if u.User != nil {
+		o.user = u.User
+	}
+	return o, nil
+}
+
+// URLFor returns the URL of the proxy reaching the given target, nil when it
+// is reached directly. The scheme of the target picks the environment
+// variable of the proxy of the environment.
+func (p *Proxy) URLFor(target *url.URL) *url.URL {
+	u, err := p.resolve(&http.Request{URL: target})
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// resolve returns the URL of the proxy reaching the target of the request,
+// nil when it is reached directly. It is the Proxy of the transports left to
+// reach the proxy of the environment.
+func (p *Proxy) resolve(r *http.Request) (*url.URL, error) {
+	if p == nil || p.bypass(r.URL.Hostname()) {
+		return nil, nil
+	}
+	if p.environment != nil {
+		return p.environment(r)
+	}
+	return p.url, nil
+}
+
+// Configure makes the transport reach the upstreams through the proxy. An
+// explicit proxy is reached by DialContext, whose errors tell the proxy
+// failures apart. The proxy of the environment is left to the transport,
+// which resolves it for every request and supports every proxy scheme.
+func (p *Proxy) Configure(transport *http.Transport) {
+	if p.environment != nil {
+		transport.Proxy = p.resolveWithCredentials
+		transport.DialContext = p.dialer.DialContext
+		return
+	}
+	transport.Proxy = nil
+	transport.DialContext = p.DialContext
+}
+
+// resolveWithCredentials resolves the proxy as resolve does, the credentials
+// of p replacing the ones of the proxy URL since the transport only sends the
+// latter.
+func (p *Proxy) resolveWithCredentials(r *http.Request) (*url.URL, error) {
+	u, err := p.resolve(r)
+	if u == nil || err != nil || p.user == nil {
+		return u, err
+	}
+	withCredentials := *u
+	withCredentials.User = p.user
+	return &withCredentials, nil
+}
+
+// bypass tells whether the host is in the no-proxy list.
+func (p *Proxy) bypass(host string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range p.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if ip != nil && ip.Equal(net.ParseIP(entry)) {
+			return true
+		}
+		domain := strings.ToLower(strings.TrimPrefix(entry, "."))
+		host := strings.ToLower(host)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns the headers of a CONNECT through the given proxy.
+func (p *Proxy) header(proxyURL *url.URL) http.Header {
+	header := http.Header{}
+	user := p.user
+	if user == nil {
+		user = proxyURL.User
+	}
+	if user != nil {
+		header.Set("Proxy-Authorization", basicAuth(userinfo(user)))
+	}
+	return header
+}
+
+// DialContext connects to the given address through a CONNECT tunnel of the
+// proxy, or directly when the proxy does not apply to it. It is meant to be
+// the DialContext of an http.Transport without Proxy, which then runs the TLS
+// handshake with upstream itself: the proxy of the environment is resolved as
+// for an https target, and must be an http one. Proxy failures are
+// ProxyUnreachableError and ProxyRefusedError.
+func (p *Proxy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	proxyURL := p.URLFor(&url.URL{Scheme: "https", Host: address})
+	if proxyURL == nil {
+		return p.dialer.DialContext(ctx, network, address)
+	}
+	name := redacted(proxyURL.String())
+	if proxyURL.Scheme != "http" {
+		return nil, fmt.Errorf("proxy %s not supported, only http proxies open CONNECT tunnels", name)
+	}
+	conn, err := p.dialer.DialContext(ctx, "tcp", hostPort(proxyURL))
+	if err != nil {
+		return nil, &ProxyUnreachableError{Proxy: name, Err: err}
+	}
+	tunnel, _, err := connect(ctx, conn, name, address, p.header(proxyURL))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// String returns the proxy URL without password, "environment" or "direct".
+func (p *Proxy) String() string {
+	switch {
+	case p == nil || p.environment == nil && p.url == nil:
+		return Direct
+	case p.environment != nil:
+		return "environment"
+	}
+	return redacted(p.url.String())
+}
+
+func basicAuth(credentials string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+func userinfo(u *url.Userinfo) string {
+	password, _ := u.Password()
+	return u.Username() + ":" + password
+}
@@ -0,0 +1,56 @@
+// Package logging configures logrus for the service and carries
+// request-scoped loggers through the request context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of the logs. Format is either json, expected by
+// the platform, or text, easier to read on a terminal.
+type Config struct {
+	Level  string `value:"log.level|info" validate:"oneof=trace debug info warn warning error fatal panic"`
+	Format string `value:"log.format|json" validate:"oneof=json text"`
+}
+
+// Configure sets the level and the format of the standard logrus logger,
+// which writes to the standard output.
+func Configure(config Config) error {
+	level, err := logrus.ParseLevel(config.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %s: %v", config.Level, err)
+	}
+	var formatter logrus.Formatter
+	switch config.Format {
+	case "json":
+		formatter = &logrus.JSONFormatter{}
+	case "text":
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	default:
+		return fmt.Errorf("invalid log format %s, json or text expected", config.Format)
+	}
+	logrus.SetLevel(level)
+	logrus.SetFormatter(formatter)
+	logrus.SetOutput(os.Stdout)
+	return nil
+}
+
+type loggerKey struct{}
+
+// FromContext returns the logger of the request, or the standard logger when
+// the context does not carry any.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// WithFields returns a context whose logger adds the given fields.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, loggerKey{}, FromContext(ctx).WithFields(fields))
+}
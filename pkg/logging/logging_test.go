@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigure(t *testing.T) {
+	require.Nil(t, Configure(Config{Level: "debug", Format: "text"}))
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+	require.Nil(t, Configure(Config{Level: "warn", Format: "json"}))
+	assert.Equal(t, logrus.WarnLevel, logrus.GetLevel())
+
+	assert.EqualError(t, Configure(Config{Level: "loud", Format: "json"}), `invalid log level loud: not a valid logrus Level: "loud"`)
+	assert.EqualError(t, Configure(Config{Level: "info", Format: "xml"}), "invalid log format xml, json or text expected")
+}
+
+func TestMiddleware(t *testing.T) {
+	require.Nil(t, Configure(Config{Level: "info", Format: "json"}))
+	var out bytes.Buffer
+	logrus.SetOutput(&out)
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/stations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handled")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/stations/42?direction=arrivals", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req.WithContext(WithFields(req.Context(), logrus.Fields{"request_id": "abc"})))
+
+	dec := json.NewDecoder(&out)
+	var handled, served map[string]interface{}
+	require.Nil(t, dec.Decode(&handled))
+	require.Nil(t, dec.Decode(&served))
+	assert.Equal(t, "handled", handled["msg"])
+	assert.Equal(t, "abc", handled["request_id"])
+	assert.Equal(t, "/stations/{id}", handled["route"])
+	assert.Equal(t, "request served", served["msg"])
+	assert.Equal(t, "abc", served["request_id"])
+	assert.Equal(t, float64(http.StatusTeapot), served["status"])
+	assert.Equal(t, "direction=arrivals", served["query"])
+	assert.Contains(t, served, "latency_ms")
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Middleware logs every request once served, with its route, status and
+// latency. The handlers find a logger carrying the route in the request
+// context. It must be used through mux.Router.Use so that the route is known.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := WithFields(r.Context(), logrus.Fields{"route": Route(r)})
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		FromContext(ctx).WithFields(logrus.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"query":      r.URL.RawQuery,
+			"status":     rec.Status,
+			"latency_ms": time.Since(start).Milliseconds(),
+		}).Info("request served")
+	})
+}
+
+// Route returns the path template of the route matching the request, its
+// path when the request was not routed by mux.
+func Route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// StatusRecorder records the status written by a handler. It keeps the
+// response flushable for the streaming routes.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+// NewStatusRecorder returns a recorder of w, the status being 200 until the
+// handler writes another one.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
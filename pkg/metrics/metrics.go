@@ -0,0 +1,277 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in
+// the Prometheus text format. It covers what the service measures, no more:
+// series are labelled, created on first use and never deleted.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics exposed by a /metrics route.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Errorf("metric %s registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// GaugeFunc registers a gauge without labels whose value is read from fn when
+// the metrics are written.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{help: help, fn: fn})
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{vec: newVec(help, "histogram", labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// WriteText writes all the metrics in the Prometheus text format, sorted by
+// name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := r.metrics
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		metrics[name].write(w, name)
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// vec holds the series of a metric, one per combination of label values.
+type vec struct {
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	// buckets and count are only used by histograms, value being the sum.
+	buckets []uint64
+	count   uint64
+}
+
+func newVec(help, kind string, labels []string) vec {
+	return vec{help: help, kind: kind, labels: labels, series: map[string]*series{}}
+}
+
+// with returns the series of the given label values, created on first use.
+// It must be called with the lock held.
+func (v *vec) with(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Errorf("%d label values given for labels %v", len(values), v.labels))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		pairs := make([]string, len(values))
+		for i, value := range values {
+			pairs[i] = v.labels[i] + `="` + escapeLabel(value) + `"`
+		}
+		s = &series{labels: strings.Join(pairs, ",")}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) writeHeader(w io.Writer, name string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(v.help), name, v.kind)
+}
+
+// sorted returns the series ordered by labels. It must be called with the
+// lock held.
+func (v *vec) sorted() []*series {
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].labels < all[j].labels })
+	return all
+}
+
+func (v *vec) write(w io.Writer, name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w, name)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", name, braces(s.labels), formatFloat(s.value))
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	vec
+}
+
+// Inc adds one to the series of the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta, which must not be negative, to the series of the given
+// label values.
+func (c *Counter) Add(delta float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.with(values).value += delta
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	vec
+}
+
+// Set sets the series of the given label values.
+func (g *Gauge) Set(value float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.with(values).value = value
+}
+
+// Add adds delta to the series of the given label values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.with(values).value += delta
+}
+
+type gaugeFunc struct {
+	help string
+	fn   func() float64
+}
+
+func (g *gaugeFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, escapeHelp(g.help), name, name, formatFloat(g.fn()))
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// Observe adds an observation to the series of the given label values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.with(values)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, name)
+	for _, s := range h.sorted() {
+		sep := ""
+		if s.labels != "" {
+			sep = ","
+		}
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, s.labels, sep, formatFloat(bound), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, s.labels, sep, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(s.labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, braces(s.labels), s.count)
+	}
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
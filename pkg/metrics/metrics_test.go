@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("requests_total", "Requests served.", "route", "status")
+	inFlight := registry.Gauge("requests_in_flight", "Requests being served.")
+	latency := registry.Histogram("request_duration_seconds", "Latency.", []float64{0.1, 1}, "route")
+	registry.GaugeFunc("circuit_state", "State of the circuit,\\n or not.", func() float64 { return 2 })
+
+	requests.Inc("/stations", "200")
+	requests.Add(2, "/stations", "200")
+	requests.Inc("/connections", "502")
+	requests.Inc(`/a"b`, "200")
+	inFlight.Add(1)
+	inFlight.Add(1)
+	inFlight.Add(-1)
+	latency.Observe(0.05, "/stations")
+	latency.Observe(0.5, "/stations")
+	latency.Observe(3, "/stations")
+
+	var out strings.Builder
+	registry.WriteText(&out)
+
+	assert.Equal(t, `# HELP circuit_state State of the circuit,\\n or not.
+# TYPE circuit_state gauge
+circuit_state 2
+# HELP request_duration_seconds Latency.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/stations",le="0.1"} 1
+request_duration_seconds_bucket{route="/stations",le="1"} 2
+request_duration_seconds_bucket{route="/stations",le="+Inf"} 3
+request_duration_seconds_sum{route="/stations"} 3.55
+request_duration_seconds_count{route="/stations"} 3
+# HELP requests_in_flight Requests being served.
+# TYPE requests_in_flight gauge
+requests_in_flight 1
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a\"b",status="200"} 1
+requests_total{route="/connections",status="502"} 1
+requests_total{route="/stations",status="200"} 3
+`, out.String())
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("requests_total", "Requests served.", "route")
+
+	assert.Panics(t, func() { registry.Gauge("requests_total", "Again.") })
+	assert.Panics(t, func() { requests.Inc("/stations", "200") })
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.Gauge("up", "Always 1.").Set(1)
+	rec := httptest.NewRecorder()
+
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "\nup 1\n")
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSpan = SpanData{
+	Name:         "GET /stations/",
+	Kind:         SpanKindClient,
+	TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+	SpanID:       "00f067aa0ba902b7",
+	ParentSpanID: "b7ad6b7169203331",
+	Start:        time.Unix(1600000000, 0),
+	End:          time.Unix(1600000000, 5000000),
+	Attributes: map[string]interface{}{
+		"net.peer.name":    "api.irail.be",
+		"http.status_code": 200,
+		"cache.hit":        false,
+	},
+	Status: StatusOK,
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	require.Nil(t, NewStdoutExporter(&out).Export(context.Background(), []SpanData{testSpan}))
+
+	var line map[string]interface{}
+	require.Nil(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "GET /stations/", line["name"])
+	assert.Equal(t, "client", line["kind"])
+	assert.Equal(t, "ok", line["status"])
+	assert.Equal(t, "api.irail.be", line["attributes"].(map[string]interface{})["net.peer.name"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "demo-egress-http", time.Second)
+	require.Nil(t, exporter.Export(context.Background(), []SpanData{testSpan}))
+
+	expected := `{"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "demo-egress-http"}}]},
+		"scopeSpans": [{
+			"scope": {"name": "eurocontrol.io/demo/egress"},
+			"spans": [{
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId": "00f067aa0ba902b7",
+				"parentSpanId": "b7ad6b7169203331",
+				"name": "GET /stations/",
+				"kind": 3,
+				"startTimeUnixNano": "1600000000000000000",
+				"endTimeUnixNano": "1600000000005000000",
+				"attributes": [
+					{"key": "cache.hit", "value": {"boolValue": false}},
+					{"key": "http.status_code", "value": {"intValue": "200"}},
+					{"key": "net.peer.name", "value": {"stringValue": "api.irail.be"}}
+				],
+				"status": {"code": 1}
+			}]
+		}]
+	}]}`
+	actual, _ := json.Marshal(received)
+	assert.JSONEq(t, expected, string(actual))
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	err := NewOTLPExporter(collector.URL, "demo-egress-http", time.Second).Export(context.Background(), []SpanData{testSpan})
+
+	assert.EqualError(t, err, "collector "+collector.URL+" answered 503 Service Unavailable")
+}
+
+func TestTracer_Batches(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(Config{ExportIntervalMillis: time.Hour, MaxQueueSize: 2}, exporter)
+	for i := 0; i < 3; i++ {
+		tracer.enqueue(testSpan)
+	}
+	assert.Empty(t, exporter.Spans())
+
+	require.Nil(t, tracer.Shutdown(context.Background()))
+
+	assert.Len(t, exporter.Spans(), 2, "spans beyond the queue size are dropped")
+}
+
+func TestConfigure(t *testing.T) {
+	previous := SetTracer(nil)
+	t.Cleanup(func() { SetTracer(previous) })
+
+	require.Nil(t, Configure(Config{Exporter: "stdout", ExportIntervalMillis: time.Second}))
+	assert.NotNil(t, currentTracer())
+	require.Nil(t, Shutdown(context.Background()))
+
+	require.Nil(t, Configure(Config{Exporter: "none"}))
+	assert.Nil(t, currentTracer())
+
+	assert.EqualError(t, Configure(Config{Exporter: "jaeger"}), "invalid tracing exporter jaeger, none, stdout or otlp expected")
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"eurocontrol.io/demo/egress/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Middleware keeps the request id of every request in its context and traces
+// the request with a server span, child of the trace context of the caller.
+// The request id is taken from the X-Request-ID header, as set by the Istio
+// sidecar, or generated, and sent back on the response. A request without a
+// valid traceparent starts a new trace. Both ids are added to the logger of
+// the request. It must be used through mux.Router.Use, before
+// logging.Middleware so that the access log carries the ids.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = randomHex(16)
+		}
+		ctx := WithRequestID(r.Context(), requestID)
+		if parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			parent.State = strings.Join(r.Header.Values(TracestateHeader), ",")
+			ctx = WithTraceContext(ctx, parent)
+		}
+		route := logging.Route(r)
+		ctx, span := Start(ctx, r.Method+" "+route, SpanKindServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		tc, _ := FromContext(ctx)
+		ctx = logging.WithFields(ctx, logrus.Fields{
+			"request_id": requestID,
+			"trace_id":   tc.TraceID,
+		})
+		w.Header().Set(RequestIDHeader, requestID)
+		rec := logging.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", rec.Status)
+		if rec.Status >= 500 {
+			span.SetStatus(StatusError, http.StatusText(rec.Status))
+		}
+		span.End()
+	})
+}
+
+// validRequestID tells whether a request id sent by a caller can be used as
+// is: printable ASCII of a reasonable length.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
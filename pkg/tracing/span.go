@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind tells whether a span serves a request or calls another service.
+// The values are the ones of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+func (k SpanKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// StatusCode is the outcome of a span. The values are the ones of OTLP.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	default:
+		return "unset"
+	}
+}
+
+func (c StatusCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// SpanData is an ended span, as given to the exporters.
+type SpanData struct {
+	Name          string                 `json:"name"`
+	Kind          SpanKind               `json:"kind"`
+	TraceID       string                 `json:"traceId"`
+	SpanID        string                 `json:"spanId"`
+	ParentSpanID  string                 `json:"parentSpanId,omitempty"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Status        StatusCode             `json:"status"`
+	StatusMessage string                 `json:"statusMessage,omitempty"`
+}
+
+// Span is an operation being traced. A nil span records nothing, which is what
+// Start returns when no tracer is set or the trace is not sampled, so that the
+// callers never have to check.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+type spanKey struct{}
+
+// Start starts a span child of the trace context carried by ctx, or the root
+// span of a new trace when there is none. The returned context carries the
+// span, and the trace context of the span to be forwarded on outbound calls.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent, ok := FromContext(ctx)
+	tc := NewTraceContext()
+	if ok {
+		tc.TraceID = parent.TraceID
+		tc.Flags = parent.Flags
+		tc.State = parent.State
+	}
+	ctx = WithTraceContext(ctx, tc)
+	tracer := currentTracer()
+	if tracer == nil || !tc.Sampled() {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: tracer,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			TraceID:    tc.TraceID,
+			SpanID:     tc.ParentID,
+			Start:      time.Now(),
+			Attributes: map[string]interface{}{},
+		},
+	}
+	if ok {
+		span.data.ParentSpanID = parent.ParentID
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext returns the span carried by ctx, nil when there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttribute sets an attribute of the span. Strings, booleans, integers and
+// floats are exported as such, other values as their string representation.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+// SetStatus sets the outcome of the span.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Status = code
+		s.data.StatusMessage = message
+	}
+}
+
+// RecordError marks the span as failed with the given error.
+func (s *Span) RecordError(err error) {
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End ends the span and hands it over to the tracer. The span cannot be
+// changed anymore.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useExporter traces the spans of the test synchronously into an in-memory
+// exporter.
+func useExporter(t *testing.T) *InMemoryExporter {
+	exporter := NewInMemoryExporter()
+	previous := SetTracer(NewTracer(Config{}, exporter))
+	t.Cleanup(func() { SetTracer(previous) })
+	return exporter
+}
+
+func TestStart(t *testing.T) {
+	exporter := useExporter(t)
+	parent := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentID: "00f067aa0ba902b7", Flags: 1}
+
+	ctx, span := Start(WithTraceContext(context.Background(), parent), "GET /stations", SpanKindServer)
+	_, child := Start(ctx, "GET /stations/", SpanKindClient)
+	child.SetAttribute("retry.attempt", 1)
+	child.RecordError(errors.New("connection refused"))
+	child.End()
+	span.End()
+	span.SetAttribute("ignored", true)
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET /stations/", spans[0].Name)
+	assert.Equal(t, SpanKindClient, spans[0].Kind)
+	assert.Equal(t, parent.TraceID, spans[0].TraceID)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, 1, spans[0].Attributes["retry.attempt"])
+	assert.Equal(t, StatusError, spans[0].Status)
+	assert.Equal(t, "connection refused", spans[0].StatusMessage)
+	assert.Equal(t, parent.ParentID, spans[1].ParentSpanID)
+	assert.NotContains(t, spans[1].Attributes, "ignored")
+	assert.False(t, spans[1].End.Before(spans[1].Start))
+
+	tc, _ := FromContext(ctx)
+	assert.Equal(t, spans[1].SpanID, tc.ParentID)
+	assert.Equal(t, span, SpanFromContext(ctx))
+}
+
+func TestStart_NotRecording(t *testing.T) {
+	exporter := useExporter(t)
+	parent := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentID: "00f067aa0ba902b7"}
+
+	ctx, span := Start(WithTraceContext(context.Background(), parent), "GET /stations", SpanKindServer)
+	span.SetAttribute("http.status_code", 200)
+	span.End()
+
+	assert.Nil(t, span)
+	assert.Empty(t, exporter.Spans())
+	tc, _ := FromContext(ctx)
+	assert.Equal(t, parent.TraceID, tc.TraceID)
+	assert.False(t, tc.Sampled())
+
+	SetTracer(nil)
+	ctx, span = Start(context.Background(), "GET /stations", SpanKindServer)
+	assert.Nil(t, span)
+	_, ok := FromContext(ctx)
+	assert.True(t, ok, "the trace context is propagated without tracer")
+}
+
+func TestMiddleware_ServerSpan(t *testing.T) {
+	exporter := useExporter(t)
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/stations/{id}/liveboard", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/stations/42/liveboard?direction=arrivals", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /stations/{id}/liveboard", spans[0].Name)
+	assert.Equal(t, SpanKindServer, spans[0].Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+	assert.Equal(t, "/stations/{id}/liveboard", spans[0].Attributes["http.route"])
+	assert.Equal(t, "/stations/42/liveboard?direction=arrivals", spans[0].Attributes["http.target"])
+	assert.Equal(t, http.StatusBadGateway, spans[0].Attributes["http.status_code"])
+	assert.Equal(t, StatusError, spans[0].Status)
+}
@@ -0,0 +1,143 @@
+// Package tracing correlates the requests served with the calls made to
+// upstream: the request id and the W3C trace context of an inbound request are
+// kept in its context and forwarded on the outbound calls. Requests and calls
+// are traced with OpenTelemetry-like spans, exported to a collector over
+// OTLP/HTTP or to the standard output.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// RequestIDHeader is the header carrying the id of a request.
+	RequestIDHeader = "X-Request-ID"
+	// TraceparentHeader is the W3C header carrying the trace and the parent
+	// span of a request.
+	TraceparentHeader = "traceparent"
+	// TracestateHeader is the W3C header carrying the vendor specific trace
+	// data, forwarded as is.
+	TracestateHeader = "tracestate"
+)
+
+// maxRequestIDLength bounds the request ids accepted from the callers, they
+// end up in every log line.
+const maxRequestIDLength = 128
+
+// TraceContext is the W3C trace context of a request.
+type TraceContext struct {
+	// TraceID is the id of the whole trace, 32 lowercase hex digits.
+	TraceID string
+	// ParentID is the id of the calling span, 16 lowercase hex digits.
+	ParentID string
+	// Flags are the trace flags, the lowest bit telling whether the caller
+	// records the trace.
+	Flags byte
+	// State is the tracestate header, opaque to the service.
+	State string
+}
+
+// ParseTraceparent parses a traceparent header. Versions above 00 are parsed
+// as far as version 00 goes, as the specification requires.
+func ParseTraceparent(header string) (TraceContext, error) {
+	header = strings.TrimSpace(header)
+	invalid := fmt.Errorf("invalid traceparent %q", header)
+	if len(header) < 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return TraceContext{}, invalid
+	}
+	version := header[:2]
+	if !isHex(version) || version == "ff" {
+		return TraceContext{}, invalid
+	}
+	if version == "00" && len(header) != 55 || len(header) > 55 && header[55] != '-' {
+		return TraceContext{}, invalid
+	}
+	tc := TraceContext{TraceID: header[3:35], ParentID: header[36:52]}
+	flags := header[53:55]
+	if !isHex(tc.TraceID) || isZero(tc.TraceID) || !isHex(tc.ParentID) || isZero(tc.ParentID) || !isHex(flags) {
+		return TraceContext{}, invalid
+	}
+	b, _ := hex.DecodeString(flags)
+	tc.Flags = b[0]
+	return tc, nil
+}
+
+// Traceparent returns the version 00 traceparent header of the context.
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, tc.ParentID, tc.Flags)
+}
+
+// Sampled tells whether the caller records the trace.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&1 == 1
+}
+
+// NewTraceContext starts a new sampled trace, for the requests coming without
+// any.
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceID: randomHex(16), ParentID: randomHex(8), Flags: 1}
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type requestIDKey struct{}
+
+type traceContextKey struct{}
+
+// WithRequestID returns a context carrying the given request id.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id carried by the context, if any.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// WithTraceContext returns a context carrying the given trace context.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// FromContext returns the trace context carried by the context, if any.
+func FromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// Inject sets the request id and the trace context carried by the context on
+// the headers of an outbound request.
+func Inject(ctx context.Context, header http.Header) {
+	if requestID := RequestID(ctx); requestID != "" {
+		header.Set(RequestIDHeader, requestID)
+	}
+	if tc, ok := FromContext(ctx); ok {
+		header.Set(TraceparentHeader, tc.Traceparent())
+		if tc.State != "" {
+			header.Set(TracestateHeader, tc.State)
+		}
+	}
+}
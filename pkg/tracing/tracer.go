@@ -0,0 +1,176 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of the tracer. Exporter is either none, stdout
+// to write the spans as JSON lines for local runs, or otlp to send them to an
+// OpenTelemetry collector over OTLP/HTTP. The ended spans are exported in
+// batches every ExportIntervalMillis, at most MaxQueueSize of them being kept
+// in between.
+type Config struct {
+	Exporter             string        `value:"tracing.exporter|none" validate:"oneof=none stdout otlp"`
+	ServiceName          string        `value:"tracing.service-name|demo-egress-http"`
+	OTLPEndpoint         string        `value:"tracing.otlp.endpoint|http://localhost:4318/v1/traces" validate:"url"`
+	OTLPTimeoutMillis    time.Duration `value:"tracing.otlp.timeout|5000"`
+	ExportIntervalMillis time.Duration `value:"tracing.export-interval|5000"`
+	MaxQueueSize         int           `value:"tracing.max-queue-size|2048" validate:"min=1"`
+}
+
+// Exporter sends the ended spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer collects the ended spans and exports them. A tracer with no export
+// interval exports every span as soon as it ends, which is what the tests
+// want.
+type Tracer struct {
+	exporter Exporter
+	interval time.Duration
+	maxQueue int
+
+	mu      sync.Mutex
+	queue   []SpanData
+	dropped int
+
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewTracer returns a tracer exporting to the given exporter. Its export loop
+// runs until Shutdown.
+func NewTracer(config Config, exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		interval: config.ExportIntervalMillis,
+		maxQueue: config.MaxQueueSize,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if t.interval > 0 {
+		go t.run()
+	} else {
+		close(t.stopped)
+	}
+	return t
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), t.interval)
+			t.Flush(ctx)
+			cancel()
+		}
+	}
+}
+
+func (t *Tracer) enqueue(span SpanData) {
+	if t.interval <= 0 {
+		t.exportSpans(context.Background(), []SpanData{span})
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.maxQueue > 0 && len(t.queue) >= t.maxQueue {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, span)
+}
+
+// Flush exports the spans ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		logrus.WithField("dropped", dropped).Warn("span queue full, spans dropped")
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exportSpans(ctx, spans)
+}
+
+func (t *Tracer) exportSpans(ctx context.Context, spans []SpanData) error {
+	err := t.exporter.Export(ctx, spans)
+	if err != nil {
+		logrus.WithError(err).WithField("spans", len(spans)).Warn("spans not exported")
+	}
+	return err
+}
+
+// Shutdown stops the export loop and exports the remaining spans.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.once.Do(func() { close(t.stop) })
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.Flush(ctx)
+}
+
+var (
+	globalMu sync.RWMutex
+	global   *Tracer
+)
+
+func currentTracer() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// SetTracer sets the tracer of the spans started from now on, nil disabling
+// the tracing, and returns the previous one.
+func SetTracer(t *Tracer) *Tracer {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	previous := global
+	global = t
+	return previous
+}
+
+// Configure sets the tracer of the service according to the configuration.
+func Configure(config Config) error {
+	var exporter Exporter
+	switch config.Exporter {
+	case "none":
+		SetTracer(nil)
+		return nil
+	case "stdout":
+		exporter = NewStdoutExporter(os.Stdout)
+	case "otlp":
+		exporter = NewOTLPExporter(config.OTLPEndpoint, config.ServiceName, config.OTLPTimeoutMillis)
+	default:
+		return fmt.Errorf("invalid tracing exporter %s, none, stdout or otlp expected", config.Exporter)
+	}
+	SetTracer(NewTracer(config, exporter))
+	return nil
+}
+
+// Shutdown exports the remaining spans of the tracer of the service, if any.
+func Shutdown(ctx context.Context) error {
+	if t := currentTracer(); t != nil {
+		return t.Shutdown(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.Nil(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", tc.ParentID)
+	assert.True(t, tc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", tc.Traceparent())
+
+	tc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	require.Nil(t, err)
+	assert.False(t, tc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", tc.Traceparent())
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x",
+	} {
+		_, err := ParseTraceparent(header)
+		assert.NotNil(t, err, header)
+	}
+}
+
+func TestNewTraceContext(t *testing.T) {
+	tc := NewTraceContext()
+	parsed, err := ParseTraceparent(tc.Traceparent())
+	require.Nil(t, err)
+	assert.Equal(t, tc, parsed)
+	assert.NotEqual(t, tc.TraceID, NewTraceContext().TraceID)
+}
+
+func serve(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, context.Context) {
+	t.Helper()
+	var ctx context.Context
+	rec := httptest.NewRecorder()
+	Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(rec, req)
+	require.NotNil(t, ctx)
+	return rec, ctx
+}
+
+func TestMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/stations", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Add(TracestateHeader, "congo=t61rcWkgMzE")
+	req.Header.Add(TracestateHeader, "rojo=00f067aa0ba902b7")
+	rec, ctx := serve(t, req)
+
+	assert.Equal(t, "abc-123", rec.Header().Get(RequestIDHeader))
+	assert.Equal(t, "abc-123", RequestID(ctx))
+	tc, ok := FromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+	assert.NotEqual(t, "00f067aa0ba902b7", tc.ParentID, "the server span is the parent of the outbound calls")
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", tc.State)
+}
+
+func TestMiddleware_NewIDs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/stations", nil)
+	req.Header.Set(RequestIDHeader, "with\nnewline")
+	req.Header.Set(TraceparentHeader, "garbage")
+	req.Header.Set(TracestateHeader, "congo=t61rcWkgMzE")
+	rec, ctx := serve(t, req)
+
+	assert.Len(t, RequestID(ctx), 32)
+	assert.Equal(t, RequestID(ctx), rec.Header().Get(RequestIDHeader))
+	tc, ok := FromContext(ctx)
+	require.True(t, ok)
+	assert.Len(t, tc.TraceID, 32)
+	assert.True(t, tc.Sampled())
+	assert.Empty(t, tc.State, "tracestate is dropped with an invalid traceparent")
+}
+
+func TestInject(t *testing.T) {
+	header := http.Header{}
+	Inject(context.Background(), header)
+	assert.Empty(t, header)
+
+	ctx := WithRequestID(context.Background(), "abc-123")
+	ctx = WithTraceContext(ctx, TraceContext{
+		TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		ParentID: "00f067aa0ba902b7",
+		Flags:    1,
+		State:    "congo=t61rcWkgMzE",
+	})
+	Inject(ctx, header)
+	assert.Equal(t, "abc-123", header.Get(RequestIDHeader))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", header.Get(TraceparentHeader))
+	assert.Equal(t, "congo=t61rcWkgMzE", header.Get(TracestateHeader))
+}